@@ -0,0 +1,175 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/go-web-kits/cache/redislock"
+	"github.com/pkg/errors"
+)
+
+// CoordinationTTL is how long the state of a barrier or a latch is kept since its last change,
+// so the abandoned ones are cleaned up.
+var CoordinationTTL = 24 * time.Hour
+
+var (
+	// KEYS: latch
+	// ARGV: count, ttl (ms), channel
+	luaCountDown = redis.NewScript(`
+local n = tonumber(redis.call("get", KEYS[1]) or ARGV[1]) - 1
+if n < 0 then
+	n = 0
+end
+redis.call("set", KEYS[1], n, "px", ARGV[2])
+if n == 0 then
+	redis.call("publish", ARGV[3], KEYS[1])
+end
+return n`)
+	// KEYS: barrier
+	// ARGV: parties, ttl (ms), channel
+	luaBarrierAwait = redis.NewScript(`
+local gen = tonumber(redis.call("hget", KEYS[1], "generation") or "0")
+local n = redis.call("hincrby", KEYS[1], "arrived", 1)
+if n >= tonumber(ARGV[1]) then
+	redis.call("hset", KEYS[1], "arrived", 0)
+	redis.call("hincrby", KEYS[1], "generation", 1)
+	redis.call("publish", ARGV[3], KEYS[1])
+end
+redis.call("pexpire", KEYS[1], ARGV[2])
+return {gen, n}`)
+	// KEYS: barrier
+	// ARGV: generation
+	luaBarrierLeave = redis.NewScript(`
+if tonumber(redis.call("hget", KEYS[1], "generation") or "0") ~= tonumber(ARGV[1]) then
+	return 0
+end
+if tonumber(redis.call("hget", KEYS[1], "arrived") or "0") > 0 then
+	redis.call("hincrby", KEYS[1], "arrived", -1)
+end
+return 1`)
+)
+
+// LatchHandle is a countdown latch shared by the workers: the waiters are released when it is
+// counted down to 0 (by any of the workers).
+type LatchHandle struct {
+	name  string
+	count int64
+}
+
+// CountDownLatch returns the latch of the name, which starts from the count.
+func CountDownLatch(name string, count int64) *LatchHandle {
+	return &LatchHandle{name: name, count: count}
+}
+
+// Name returns the name of the latch.
+func (l *LatchHandle) Name() string {
+	return l.name
+}
+
+// CountDown decreases the count and returns the remaining count.
+func (l *LatchHandle) CountDown() (int64, error) {
+	key := l.key()
+	n, err := luaCountDown.Run(cliFor(key), []string{key}, l.count,
+		int64(CoordinationTTL/time.Millisecond), redislock.ReleaseChannel(key)).Int64()
+	return n, errors.Wrap(err, "cache.LatchHandle#CountDown")
+}
+
+// Count returns the remaining count.
+func (l *LatchHandle) Count() (int64, error) {
+	n, err := cliFor(l.key()).Get(l.key()).Int64()
+	if IsKeyNotFound(err) {
+		return l.count, nil
+	}
+	return n, errors.Wrap(err, "cache.LatchHandle#Count")
+}
+
+// Wait blocks until the count is 0, or the ctx is done.
+func (l *LatchHandle) Wait(ctx context.Context) error {
+	err := waitFor(ctx, l.key(), func() (bool, error) {
+		n, err := l.Count()
+		return n <= 0, err
+	})
+	return errors.Wrap(err, "cache.LatchHandle#Wait")
+}
+
+func (l *LatchHandle) key() string {
+	return "__latch:" + l.name
+}
+
+// BarrierHandle is a cyclic barrier shared by the workers: each Await blocks until the parties
+// have all arrived, then the barrier is reset for the next round.
+type BarrierHandle struct {
+	name    string
+	parties int64
+}
+
+// Barrier returns the barrier of the name for the parties.
+func Barrier(name string, parties int64) *BarrierHandle {
+	return &BarrierHandle{name: name, parties: parties}
+}
+
+// Name returns the name of the barrier.
+func (b *BarrierHandle) Name() string {
+	return b.name
+}
+
+// Parties returns the count of the parties to arrive in each round.
+func (b *BarrierHandle) Parties() int64 {
+	return b.parties
+}
+
+// Await arrives at the barrier, and blocks until the other parties of the round arrive,
+// or the ctx is done (then the arrival is withdrawn).
+func (b *BarrierHandle) Await(ctx context.Context) error {
+	key := b.key()
+	res, err := luaBarrierAwait.Run(cliFor(key), []string{key}, b.parties,
+		int64(CoordinationTTL/time.Millisecond), redislock.ReleaseChannel(key)).Result()
+	if err != nil {
+		return errors.Wrap(err, "cache.BarrierHandle#Await")
+	}
+
+	gen, arrived := res.([]interface{})[0].(int64), res.([]interface{})[1].(int64)
+	if arrived >= b.parties {
+		return nil
+	}
+
+	err = waitFor(ctx, key, func() (bool, error) {
+		current, err := cliFor(key).HGet(key, "generation").Int64()
+		return current > gen, filtered(err)
+	})
+	if err != nil && err == ctx.Err() {
+		if left, leaveErr := luaBarrierLeave.Run(cliFor(key), []string{key}, gen).Int64(); leaveErr == nil && left == 0 {
+			return nil // tripped meanwhile
+		}
+	}
+	return errors.Wrap(err, "cache.BarrierHandle#Await")
+}
+
+func (b *BarrierHandle) key() string {
+	return "__barrier:" + b.name
+}
+
+// waitFor waits until done, which is checked on the release notifications of the key,
+// and every `SpinInterval` (e.g. when the client does not support pub/sub).
+func waitFor(ctx context.Context, key string, done func() (bool, error)) error {
+	wake, stop := redislock.Notifications(cliFor(key), key)
+	defer stop()
+	ticker := time.NewTicker(SpinInterval)
+	defer ticker.Stop()
+
+	for {
+		// subscribed before checking, so a release in between is not missed
+		ok, err := done()
+		if err != nil || ok {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wake:
+		case <-ticker.C:
+		}
+	}
+}
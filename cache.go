@@ -1,30 +1,70 @@
 package cache
 
 import (
+	"context"
 	l "log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v7"
+	"github.com/go-web-kits/cache/redislock"
 	"github.com/go-web-kits/dbx"
 	"github.com/pkg/errors"
 )
 
-var Cli *redis.Client
+var Cli redis.UniversalClient
 var Logger interface{ Println(args ...interface{}) } = l.New(os.Stdout, "\r\n", 0)
 var UnLog = false
+var DefaultScanCount int64 = 100
 
 type Opt struct {
 	UnderLocking bool
 	FailIfLocked bool
+	WaitTimeout  time.Duration // Max wait of UnderLocking, 0 means no limit
 	ExpiresIn    time.Duration
-	Default      interface{} // could be `func() interface{}` or other value type
+	Default      interface{} // could be `func() interface{}`, `func(ctx context.Context) interface{}` or other value type
 	Force        bool
 	To           interface{} // Unmarshal to the object (pointer)
+	Tags         []string    // Record the key under the tags, see `InvalidateTags`
+	ReadPrimary  bool        // Get from the primary even if `Replicas` are configured (read-your-writes)
+	Fence        int64       // Fencing token of the lock (`Lock.Fence()`), the write is rejected if it is stale
+	ScanCount    int64       // COUNT hint of each SCAN in DeleteMatched, default `DefaultScanCount`
+	RateLimit    int         // Max keys deleted per second by DeleteMatched, 0 means unlimited
+	// Called after each batch deleted by DeleteMatched, with the count deleted so far
+	Progress func(deleted int64)
+	// Makes the backoffs between the re-checks of UnderLocking (besides the release notifications), default
+	// `LinearBackoff(SpinInterval)`, giving up (ErrWaitTimeout) when it does. Called per wait, as the strategies are stateful
+	RetryStrategy func() redislock.RetryStrategy
+	// Carries the parent span of the operation, see `Tracing`
+	Context context.Context
 	// ZeroValue interface{}
 }
 
-func Init(cli *redis.Client, logger interface{ Println(args ...interface{}) }) {
+// load runs the loader of Fetch in a child span.
+func load(ctx context.Context, loader func(ctx context.Context) interface{}) (val interface{}, err error) {
+	ctx, span := startSpan(ctx, "cache.Fetch.loader")
+	defer func() { endSpan(span, err) }()
+	incMetric(MetricLoads, nil)
+	start := time.Now()
+	val = loader(ctx)
+	observeMetric(MetricLoadDuration, nil, time.Since(start))
+
+	if e, ok := val.(error); ok {
+		err = e
+	} else if result, ok := val.(dbx.Result); ok {
+		val, err = result.Data, result.Err
+	}
+	if err != nil {
+		incMetric(MetricLoadErrors, nil)
+		return nil, err
+	}
+	return val, nil
+}
+
+// Init with a redis.UniversalClient: *redis.Client, a sentinel (failover) client or *redis.ClusterClient.
+// For a cluster, the multi-key operations are split per hash slot.
+func Init(cli redis.UniversalClient, logger interface{ Println(args ...interface{}) }) {
 	Cli = cli
 	Cli.AddHook(Hook{})
 	if logger != nil {
@@ -32,21 +72,40 @@ func Init(cli *redis.Client, logger interface{ Println(args ...interface{}) }) {
 	}
 }
 
-func Get(key string, opts ...Opt) (interface{}, error) {
+func Get(key string, opts ...Opt) (_ interface{}, err error) {
 	var value string
-	var err error
 	opt := optGet(opts)
+	ctx, span := startSpan(opt.Context, "cache.Get")
+	span.SetAttribute("cache.key", key)
+	defer func() { endSpan(span, err) }()
 
-	err = spinning([]string{key}, opt)
+	err = spinning([]string{key}, opt, true)
 	if err != nil {
 		return nil, errors.Wrap(err, "cache.Get")
 	}
 
-	value, err = Cli.Get(key).Result()
+	err = readFrom(key, opt, func(cli redis.UniversalClient) error {
+		value, err = withContext(commandContext(ctx, opt), cli).Get(key).Result()
+		return err
+	})
+	if err == nil {
+		incMetric(MetricHits, nil)
+	} else if IsKeyNotFound(err) {
+		incMetric(MetricMisses, nil)
+	}
+	span.SetAttribute("cache.hit", err == nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "cache.Get")
 	}
 
+	span.SetAttribute("cache.size", len(value))
+	if Tracing != nil {
+		span.SetAttribute("cache.codec", codecOf(value))
+	}
+	return unpack(value, opt)
+}
+
+func unpack(value string, opt Opt) (interface{}, error) {
 	decoded, err := decode(value)
 	if err != nil {
 		// returns the un-decoded value
@@ -54,25 +113,41 @@ func Get(key string, opts ...Opt) (interface{}, error) {
 		// return nil, errors.Wrap(err, "redis.Cli.Get")
 	}
 
-	if len(opts) > 0 && opts[0].To != nil {
-		return UnCompress(decoded, opts[0].To)
+	if opt.To != nil {
+		return UnCompress(decoded, opt.To)
 	}
 	return UnCompress(decoded)
 }
 
-func Set(key string, value interface{}, opts ...Opt) error {
+func Set(key string, value interface{}, opts ...Opt) (err error) {
 	opt := optGet(opts)
+	ctx, span := startSpan(opt.Context, "cache.Set")
+	span.SetAttribute("cache.key", key)
+	defer func() { endSpan(span, err) }()
+
 	compressed, err := Compress(value)
 	if err != nil {
 		return errors.Wrap(err, "cache.Set")
 	}
+	span.SetAttribute("cache.codec", compressed[:strings.Index(compressed, "##")])
+	encoded := encode(compressed)
+	span.SetAttribute("cache.size", len(encoded))
 
-	err = spinning([]string{key}, opt)
+	err = spinning([]string{key}, opt, false)
 	if err != nil {
 		return errors.Wrap(err, "cache.Set")
 	}
 
-	err = Cli.Set(key, encode(compressed), opt.ExpiresIn).Err()
+	if opt.Fence > 0 && len(opt.Tags) > 0 {
+		return errors.New("cache.Set: Opt.Fence can not be used with Opt.Tags")
+	} else if len(opt.Tags) > 0 {
+		err = setTagged(commandContext(ctx, opt), key, encoded, opt)
+	} else {
+		err = setUntagged(commandContext(ctx, opt), key, encoded, opt)
+	}
+	if err == nil {
+		incMetric(MetricSets, nil)
+	}
 	if err == nil && opt.To != nil {
 		_, err = UnCompress(compressed, opt.To)
 	}
@@ -83,7 +158,14 @@ func Set(key string, value interface{}, opts ...Opt) error {
 // val, err := Fetch("key", Opt{Force: true})  => Key Not Found is error
 // val, err := Fetch("key", Opt{Default: ...}) => Err when the Set() call fails
 func Fetch(key string, opts ...Opt) (val interface{}, err error) {
-	val, err = Get(key, opts...)
+	opt := optGet(opts)
+	ctx, span := startSpan(opt.Context, "cache.Fetch")
+	span.SetAttribute("cache.key", key)
+	defer func() { endSpan(span, err) }()
+	opt.Context = ctx
+
+	val, err = Get(key, opt)
+	span.SetAttribute("cache.hit", err == nil)
 	if err == nil {
 		return val, nil // Cache Matched
 	}
@@ -92,23 +174,21 @@ func Fetch(key string, opts ...Opt) (val interface{}, err error) {
 		return val, filtered(err) // Key Not Found is not error
 	}
 
-	opt := opts[0]
 	if opt.Force {
 		return val, errors.Wrap(err, "cache.Fetch") // Key Not Found is error
 	}
 
 	if opt.Default != nil {
 
-		if f, ok := opt.Default.(func() interface{}); ok {
-			val = f()
-			if e, ok := val.(error); ok {
-				return nil, e
-			}
-			if result, ok := val.(dbx.Result); ok {
-				if result.Err != nil {
-					return nil, result.Err
-				}
-				val = result.Data
+		loader, ok := opt.Default.(func(ctx context.Context) interface{})
+		if f, isFunc := opt.Default.(func() interface{}); isFunc {
+			loader, ok = func(context.Context) interface{} { return f() }, true
+		}
+
+		if ok {
+			val, err = load(ctx, loader)
+			if err != nil {
+				return nil, err
 			}
 		} else {
 			val = opt.Default
@@ -121,30 +201,76 @@ func Fetch(key string, opts ...Opt) (val interface{}, err error) {
 }
 
 func Delete(keys ...string) error {
-	return Cli.Del(keys...).Err()
+	return forEachBatch(keys, func(cli redis.UniversalClient, keys []string) error {
+		return cli.Del(keys...).Err()
+	})
 }
 
 func DeleteUnderSpinLock(keys ...string) error {
-	err := spinning(keys, Opt{UnderLocking: true})
+	err := spinning(keys, Opt{UnderLocking: true}, false)
 	if err != nil {
 		return errors.Wrap(err, "cache.DeleteUnderSpinLock")
 	}
 	return Delete(keys...)
 }
 
-func DeleteMatched(pattern string, opts ...Opt) error {
+// DeleteMatched walks the keys matching the pattern by SCAN (non-blocking, on every master
+// of a cluster or every shard) and UNLINKs them page by page, returns the count of the deleted keys.
+//
+//	n, err := DeleteMatched("user:*", Opt{ScanCount: 1000, RateLimit: 5000, Progress: func(deleted int64) { ... }})
+func DeleteMatched(pattern string, opts ...Opt) (int64, error) {
 	opt := optGet(opts)
-	keys, err := Cli.Keys(pattern).Result()
-	if err != nil {
-		return errors.Wrap(err, "cache.DeleteMatched")
-	}
-	if len(keys) == 0 {
-		return nil
+	count := opt.ScanCount
+	if count <= 0 {
+		count = DefaultScanCount
 	}
 
-	err = spinning(keys, opt)
+	clients, err := nodes()
 	if err != nil {
-		return errors.Wrap(err, "cache.DeleteMatched")
+		return 0, errors.Wrap(err, "cache.DeleteMatched#Nodes")
 	}
-	return Delete(keys...)
+
+	var deleted int64
+	start := time.Now()
+	for _, client := range clients {
+		var cursor uint64
+		for {
+			keys, next, err := client.Scan(cursor, pattern, count).Result()
+			if err != nil {
+				return deleted, errors.Wrap(err, "cache.DeleteMatched#Scan")
+			}
+
+			if len(keys) > 0 {
+				err = spinning(keys, opt, false)
+				if err != nil {
+					return deleted, errors.Wrap(err, "cache.DeleteMatched")
+				}
+
+				unlink := func(cli redis.UniversalClient, keys []string) error {
+					n, err := cli.Unlink(keys...).Result()
+					deleted += n
+					return err
+				}
+				if Shards != nil {
+					// on the scanned shard, which may not own the keys on the ring any more (after AddShard)
+					err = unlink(client, keys)
+				} else {
+					err = forEachBatch(keys, unlink)
+				}
+				if err != nil {
+					return deleted, errors.Wrap(err, "cache.DeleteMatched#Unlink")
+				}
+				if opt.Progress != nil {
+					opt.Progress(deleted)
+				}
+				throttle(start, deleted, opt.RateLimit)
+			}
+
+			if next == 0 {
+				break
+			}
+			cursor = next
+		}
+	}
+	return deleted, nil
 }
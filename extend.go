@@ -11,9 +11,9 @@ func Increase(key string, value ...int) error {
 	if len(value) > 0 {
 		by = value[0]
 	}
-	_, err = Cli.IncrBy(key, int64(by)).Result()
+	_, err = cliFor(key).IncrBy(key, int64(by)).Result()
 	if err == redis.Nil {
-		err = Cli.Set(key, by, 0).Err()
+		err = cliFor(key).Set(key, by, 0).Err()
 		if err != nil {
 			return errors.Wrap(err, "redis.Cli.Increase#InitSet")
 		}
@@ -24,7 +24,7 @@ func Increase(key string, value ...int) error {
 }
 
 func IncreaseUnderSpinLock(key string, value ...int) error {
-	err := spinning([]string{key}, Opt{UnderLocking: true})
+	err := spinning([]string{key}, Opt{UnderLocking: true}, false)
 	if err != nil {
 		return errors.Wrap(err, "cache.IncreaseUnderSpinLock")
 	}
@@ -37,9 +37,9 @@ func Decrease(key string, value ...int) error {
 	if len(value) > 0 {
 		by = value[0]
 	}
-	_, err = Cli.DecrBy(key, int64(by)).Result()
+	_, err = cliFor(key).DecrBy(key, int64(by)).Result()
 	if err == redis.Nil {
-		err = Cli.Set(key, -by, 0).Err()
+		err = cliFor(key).Set(key, -by, 0).Err()
 		if err != nil {
 			return errors.Wrap(err, "redis.Cli.Decrease#InitSet")
 		}
@@ -50,7 +50,7 @@ func Decrease(key string, value ...int) error {
 }
 
 func DecreaseUnderSpinLock(key string, value ...int) error {
-	err := spinning([]string{key}, Opt{UnderLocking: true})
+	err := spinning([]string{key}, Opt{UnderLocking: true}, false)
 	if err != nil {
 		return errors.Wrap(err, "cache.DecreaseUnderSpinLock")
 	}
@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/pkg/errors"
+)
+
+// ErrStaleFence is returned by the writes carrying an older fencing token (`Opt.Fence`)
+// than the last one accepted for the key.
+var ErrStaleFence = errors.New("cache: stale fencing token")
+
+// FenceRetention is the minimum time the last accepted fencing token of a key is kept,
+// so the writes of a paused holder are still rejected after the key expired or was deleted.
+var FenceRetention = 24 * time.Hour
+
+// KEYS: key, key's fence record, key's tag index
+// ARGV: value ("" deletes the key), expiration in ms (0 means no expiration), fence, fence record expiration in ms
+// Returns {0} if the fence is stale, or {1, the tags the key was recorded in...}.
+var luaFencedWrite = redis.NewScript(`
+local last = tonumber(redis.call("get", KEYS[2]) or "0")
+if tonumber(ARGV[3]) < last then
+	return {0}
+end
+redis.call("set", KEYS[2], ARGV[3], "px", ARGV[4])
+local tags = redis.call("smembers", KEYS[3])
+redis.call("del", KEYS[3])
+
+if ARGV[1] == "" then
+	redis.call("del", KEYS[1])
+elseif tonumber(ARGV[2]) > 0 then
+	redis.call("set", KEYS[1], ARGV[1], "px", ARGV[2])
+else
+	redis.call("set", KEYS[1], ARGV[1])
+end
+return {1, unpack(tags)}`)
+
+// DeleteFenced deletes the key (and drops it from its tags) unless the fencing token is older than the last one
+// accepted for the key, see `Opt.Fence`.
+func DeleteFenced(key string, fence int64) error {
+	return errors.Wrap(fencedWrite(nil, key, "", Opt{Fence: fence}), "cache.DeleteFenced")
+}
+
+// fencedWrite checks the fence, writes the key and drops its tag index in one script,
+// so a rejected write leaves the key in its tags.
+func fencedWrite(ctx context.Context, key string, value string, opt Opt) error {
+	retention := FenceRetention
+	if opt.ExpiresIn > retention {
+		retention = opt.ExpiresIn
+	}
+
+	res, err := luaFencedWrite.Run(withContext(ctx, cliFor(key)), []string{key, fenceKey(key), tagIndexKey(key)}, value,
+		int64(opt.ExpiresIn/time.Millisecond), opt.Fence, int64(retention/time.Millisecond)).Result()
+	if err != nil {
+		return err
+	}
+	reply, _ := res.([]interface{})
+	if len(reply) == 0 || reply[0] != int64(1) {
+		return ErrStaleFence
+	}
+	tags := make([]string, 0, len(reply)-1)
+	for _, tag := range reply[1:] {
+		if tag, ok := tag.(string); ok {
+			tags = append(tags, tag)
+		}
+	}
+	return removeTagged(ctx, key, tags)
+}
+
+// fenceKey returns the key of the fence record, which is on the same cluster slot as the key.
+func fenceKey(key string) string {
+	if tag := hashTagOf(key); tag != key {
+		return key + ":__fence"
+	}
+	return HashTag(key) + ":__fence"
+}
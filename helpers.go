@@ -1,6 +1,8 @@
 package cache
 
 import (
+	"time"
+
 	"github.com/go-redis/redis/v7"
 	"github.com/go-web-kits/utils/logx"
 	"github.com/pkg/errors"
@@ -22,7 +24,10 @@ func log(op string, val string, arg interface{}) {
 	if UnLog {
 		return
 	}
+	output(op, val, arg)
+}
 
+func output(op string, val string, arg interface{}) {
 	op = logx.Blod(logx.Magenta(op))
 	if val != "" {
 		val = " `" + val + "`"
@@ -42,3 +47,22 @@ func optGet(opts []Opt) Opt {
 	}
 	return opt
 }
+
+func lockOptGet(opts []LockOpt) LockOpt {
+	var opt LockOpt
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	return opt
+}
+
+// throttle sleeps until `done` operations since `start` fit in `rate` per second.
+func throttle(start time.Time, done int64, rate int) {
+	if rate <= 0 {
+		return
+	}
+	expected := time.Duration(done) * time.Second / time.Duration(rate)
+	if elapsed := time.Since(start); elapsed < expected {
+		time.Sleep(expected - elapsed)
+	}
+}
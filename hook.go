@@ -2,8 +2,7 @@ package cache
 
 import (
 	"context"
-	"fmt"
-	"strings"
+	"log/slog"
 	"time"
 
 	"github.com/go-redis/redis/v7"
@@ -12,35 +11,63 @@ import (
 type Hook struct{}
 
 func (h Hook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	ctx, span := startCommandSpan(ctx, cmd)
+	ctx = context.WithValue(ctx, spanKey{}, span)
 	return context.WithValue(ctx, "start", time.Now()), nil
 }
 
 func (h Hook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
 	start := ctx.Value("start").(time.Time)
 	duration := time.Since(start)
-	vals := []string{}
-	for _, v := range cmd.Args()[1:] {
-		vals = append(vals, fmt.Sprint(v))
+	observeCommand(cmd, duration)
+	logCommand(ctx, cmd, duration)
+	if span, ok := ctx.Value(spanKey{}).(Span); ok {
+		endCommandSpan(span, cmd)
 	}
-
-	var content string
-	switch cmd.Name() {
-	case "get", "mget", "del", "exists":
-		content = strings.Join(vals, " ")
-	case "set", "setnx", "incrby", "decrby":
-		content = cmd.Args()[1].(string) + ":: " + strings.Join(vals[1:], ", ")
-	default:
-		content = strings.Join(vals, " ")
-	}
-
-	log(strings.ToUpper(cmd.Name()), content, duration)
 	return nil
 }
 
+// BeforeProcessPipeline starts the span of the pipeline, and the spans of its commands
+// as the children, which end together. The commands share the duration of the pipeline
+// in the metrics & the logs.
 func (h Hook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
-	return ctx, nil
+	ctx, span := startSpan(ctx, "redis.pipeline")
+	span.SetAttribute("db.system", "redis")
+	span.SetAttribute("db.commands", len(cmds))
+	spans := make([]Span, len(cmds))
+	for i, cmd := range cmds {
+		_, spans[i] = startCommandSpan(ctx, cmd)
+	}
+	ctx = context.WithValue(ctx, spanKey{}, span)
+	ctx = context.WithValue(ctx, pipelineSpansKey{}, spans)
+	return context.WithValue(ctx, "start", time.Now()), nil
 }
 
 func (h Hook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	start := ctx.Value("start").(time.Time)
+	duration := time.Since(start)
+	spans, _ := ctx.Value(pipelineSpansKey{}).([]Span)
+	var err error
+	for i, cmd := range cmds {
+		observeCommand(cmd, duration)
+		logCommand(ctx, cmd, duration, slog.Bool("pipeline", true))
+		if i < len(spans) {
+			endCommandSpan(spans[i], cmd)
+		}
+		if err == nil {
+			err = filtered(cmd.Err())
+		}
+	}
+	logPipeline(ctx, cmds, duration)
+	if span, ok := ctx.Value(spanKey{}).(Span); ok {
+		endSpan(span, err)
+	}
 	return nil
 }
+
+func observeCommand(cmd redis.Cmder, duration time.Duration) {
+	observeMetric(MetricCommandDuration, Labels{"cmd": cmd.Name()}, duration)
+	if filtered(cmd.Err()) != nil {
+		incMetric(MetricCommandErrors, Labels{"cmd": cmd.Name()})
+	}
+}
@@ -0,0 +1,160 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/go-web-kits/cache/redislock"
+	"github.com/pkg/errors"
+)
+
+// IdempotencyRetention is how long the result of `Idempotent` is kept, unless `Opt.ExpiresIn`.
+var IdempotencyRetention = 24 * time.Hour
+
+// IdempotencyClaimTTL is how long a claim of `Idempotent` is kept unless refreshed, it is refreshed
+// (every third of it) while the handler runs, so it only bounds a crashed handler.
+var IdempotencyClaimTTL = time.Minute
+
+// ErrClaimLost is returned by `Idempotent` when its claim expired (or was taken over) before the
+// result is stored, so a duplicate may have run the handler too.
+var ErrClaimLost = errors.New("cache: idempotency claim lost")
+
+const pendingPrefix = "__pending:"
+
+var (
+	// KEYS: key
+	// ARGV: claim, value ("" abandons the claim), retention (ms), channel
+	luaIdempotencyDone = redis.NewScript(`
+if redis.call("get", KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+if ARGV[2] == "" then
+	redis.call("del", KEYS[1])
+else
+	redis.call("set", KEYS[1], ARGV[2], "px", ARGV[3])
+end
+redis.call("publish", ARGV[4], KEYS[1])
+return 1`)
+	luaIdempotencyRefresh = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`)
+)
+
+// Idempotent runs the handler once for the key: the first caller claims the key and stores the
+// result (encoded like `Set`), the duplicate callers get the stored result, waiting for it while
+// the handler is in flight (up to `Opt.WaitTimeout`, then ErrWaitTimeout).
+// The result is kept for `IdempotencyRetention` (or `Opt.ExpiresIn`), and unmarshalled to `Opt.To`.
+// Every caller gets the decoded result, like `Get`, also the one which ran the handler.
+// The errors are not stored: the claim is abandoned when the handler fails (or panics),
+// so the next caller runs it again.
+func Idempotent(key string, handler func() (interface{}, error), opts ...Opt) (interface{}, error) {
+	opt := optGet(opts)
+	key = "__idem:" + key
+	claim := fmt.Sprintf("%s%s:%d:%d", pendingPrefix, hostname, os.Getpid(), time.Now().UnixNano())
+
+	ctx := context.Background()
+	if opt.WaitTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opt.WaitTimeout)
+		defer cancel()
+	}
+
+	for {
+		claimed, err := cliFor(key).SetNX(key, claim, IdempotencyClaimTTL).Result()
+		if err != nil {
+			return nil, errors.Wrap(err, "cache.Idempotent#Claim")
+		} else if claimed {
+			return runClaimed(key, claim, handler, opt)
+		}
+
+		value, err := cliFor(key).Get(key).Result()
+		if err != nil && !IsKeyNotFound(err) {
+			return nil, errors.Wrap(err, "cache.Idempotent")
+		} else if err == nil && !strings.HasPrefix(value, pendingPrefix) {
+			return unpack(value, opt)
+		} else if err != nil {
+			continue // abandoned meanwhile
+		}
+
+		err = waitFor(ctx, key, func() (bool, error) {
+			value, err := cliFor(key).Get(key).Result()
+			return IsKeyNotFound(err) || (err == nil && !strings.HasPrefix(value, pendingPrefix)), filtered(err)
+		})
+		if err == context.DeadlineExceeded {
+			return nil, errors.Wrap(ErrWaitTimeout, "cache.Idempotent")
+		} else if err != nil {
+			return nil, errors.Wrap(err, "cache.Idempotent")
+		}
+	}
+}
+
+func runClaimed(key, claim string, handler func() (interface{}, error), opt Opt) (val interface{}, err error) {
+	done := func(value string) error {
+		retention := IdempotencyRetention
+		if opt.ExpiresIn > 0 {
+			retention = opt.ExpiresIn
+		}
+		ok, err := luaIdempotencyDone.Run(cliFor(key), []string{key}, claim, value,
+			int64(retention/time.Millisecond), redislock.ReleaseChannel(key)).Int()
+		if err == nil && ok == 0 {
+			return ErrClaimLost
+		}
+		return err
+	}
+
+	stop := keepClaim(key, claim)
+	defer stop()
+
+	returned := false
+	defer func() {
+		if !returned {
+			_ = done("")
+		}
+	}()
+
+	val, err = handler()
+	returned = true
+	if err != nil {
+		_ = done("")
+		return nil, err
+	}
+
+	compressed, err := Compress(val)
+	if err != nil {
+		_ = done("")
+		return nil, errors.Wrap(err, "cache.Idempotent")
+	}
+	encoded := encode(compressed)
+	if err = done(encoded); err != nil {
+		return nil, errors.Wrap(err, "cache.Idempotent#Store")
+	}
+	// the same representation as the duplicates get
+	val, err = unpack(encoded, opt)
+	return val, errors.Wrap(err, "cache.Idempotent")
+}
+
+// keepClaim refreshes the claim of the key in background, until the returned func is called
+// or the claim is lost.
+func keepClaim(key, claim string) func() {
+	ttl := IdempotencyClaimTTL
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			ok, err := luaIdempotencyRefresh.Run(cliFor(key), []string{key}, claim, int64(ttl/time.Millisecond)).Int()
+			if err == nil && ok == 0 {
+				return
+			}
+		}
+	}()
+	return cancel
+}
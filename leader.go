@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-web-kits/cache/redislock"
+)
+
+// LeaderElector keeps at most one of the instances campaigning for the same name as the leader,
+// by holding the lock of the name (see `Lock`), which is refreshed every `LockRenewalFraction`
+// of the ttl. The followers campaign again at the same interval.
+type LeaderElector struct {
+	// OnElected is called when this instance becomes the leader, with a ctx which is cancelled
+	// on step down. It should not block (e.g. start the work in a goroutine with the ctx).
+	OnElected func(ctx context.Context)
+	// OnStepDown is called when this instance is no longer the leader.
+	OnStepDown func()
+
+	name string
+	ttl  time.Duration
+
+	mu       sync.Mutex
+	leader   bool
+	cancel   context.CancelFunc
+	watchers []chan bool
+}
+
+// NewLeaderElector returns the elector of the name, a crashed leader is replaced after the ttl.
+func NewLeaderElector(name string, ttl time.Duration) *LeaderElector {
+	return &LeaderElector{name: name, ttl: ttl}
+}
+
+// Name returns the name campaigned for.
+func (e *LeaderElector) Name() string {
+	return e.name
+}
+
+// IsLeader reports whether this instance is the leader now.
+func (e *LeaderElector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+// Watch returns a channel receiving the leadership (true when elected, false on step down)
+// on each change. Only the latest change is kept for a slow receiver.
+func (e *LeaderElector) Watch() <-chan bool {
+	ch := make(chan bool, 1)
+	e.mu.Lock()
+	e.watchers = append(e.watchers, ch)
+	e.mu.Unlock()
+	return ch
+}
+
+// Campaign campaigns for the leadership and keeps it renewed until the ctx is done, then steps
+// down (releasing the lock, so another instance takes over at once) and returns.
+// The leadership is also stepped down when the renewal fails: the lock is lost, or cannot be
+// refreshed before it expires.
+func (e *LeaderElector) Campaign(ctx context.Context) {
+	interval := time.Duration(float64(e.ttl) * LockRenewalFraction)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lock *redislock.Lock
+	var renewed time.Time
+	defer func() {
+		if lock != nil {
+			_ = lock.Release()
+			e.setLeader(false)
+		}
+	}()
+
+	for {
+		now := time.Now()
+		if lock == nil {
+			if l, err := locker(e.name).Obtain("__lock:"+e.name, e.ttl, lockOptions([]LockOpt{{Purpose: "leader"}})); err == nil {
+				lock, renewed = l, now
+				e.setLeader(true)
+			}
+		} else if err := lock.Refresh(e.ttl, nil); err == nil {
+			renewed = now
+		} else if err == redislock.ErrNotObtained || now.Sub(renewed)+interval >= e.ttl {
+			lock = nil
+			e.setLeader(false)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *LeaderElector) setLeader(leader bool) {
+	e.mu.Lock()
+	if e.leader == leader {
+		e.mu.Unlock()
+		return
+	}
+	e.leader = leader
+
+	var ctx context.Context
+	if leader {
+		ctx, e.cancel = context.WithCancel(context.Background())
+	} else if e.cancel != nil {
+		e.cancel()
+		e.cancel = nil
+	}
+
+	for _, ch := range e.watchers {
+		select {
+		case <-ch: // drops the stale change
+		default:
+		}
+		ch <- leader
+	}
+	e.mu.Unlock()
+
+	if leader && e.OnElected != nil {
+		e.OnElected(ctx)
+	} else if !leader && e.OnStepDown != nil {
+		e.OnStepDown()
+	}
+}
@@ -1,59 +1,322 @@
 package cache
 
 import (
+	"context"
 	"time"
 
+	"github.com/go-redis/redis/v7"
 	"github.com/go-web-kits/cache/redislock"
 	"github.com/pkg/errors"
 )
 
 var DistributedLock = Lock
 
-func Lock(key string, maxTTL time.Duration, lambda func() error) error {
-	locker := redislock.New(Cli)
-	lock, err := locker.Obtain("__lock:"+key, maxTTL, nil)
+// SpinInterval is how often the waits of `UnderLocking` re-check the locks by default (see
+// `Opt.RetryStrategy`), besides the release notifications (which do not cover the expirations).
+var SpinInterval = 100 * time.Millisecond
+
+// ErrWaitTimeout is returned when the locks are not released within `Opt.WaitTimeout`,
+// or before `Opt.RetryStrategy` gives up.
+var ErrWaitTimeout = errors.New("cache: wait for the lock timed out")
+
+// LockRenewalFraction is the share of the ttl after which `LockAutoRenew` refreshes the lock.
+var LockRenewalFraction = 1.0 / 3
+
+// LockBackend obtains the locks of `Lock` & `GetLock`, default (nil) is a redislock.Client
+// on the node of the key. Set it to a *redislock.Redlock (see `UseRedlock`) to survive failovers.
+var LockBackend redislock.Locker
+
+// UseRedlock makes the locks obtained on a majority of the independent instances.
+// Note that `UnderLocking` still checks the lock keys on the primary, so include it in the instances.
+func UseRedlock(clients ...redis.UniversalClient) {
+	rcs := make([]redislock.RedisClient, 0, len(clients))
+	for _, cli := range clients {
+		rcs = append(rcs, cli)
+	}
+	LockBackend = redislock.NewRedlock(rcs...)
+}
+
+// Lock runs the lambda under the lock of the key, which is released when the lambda returns,
+// fails or panics (the panic is re-raised), unless `LockOpt.KeepOnError`.
+// A failed release is reported along with the error of the lambda, whose cause is kept.
+func Lock(key string, maxTTL time.Duration, lambda func() error, opts ...LockOpt) (err error) {
+	_, span := startSpan(lockOptGet(opts).Context, "cache.Lock")
+	span.SetAttribute("cache.key", key)
+	defer func() { endSpan(span, err) }()
+
+	lock, err := locker(key).Obtain("__lock:"+key, maxTTL, lockOptions(opts))
 	if err != nil {
 		return errors.Wrap(err, "cache.Lock#ObtainKey")
 	}
+	span.SetAttribute("cache.lock.fence", lock.Fence())
+	return runLocked("cache.Lock", lockOptGet(opts), lambda, lock.Release)
+}
 
-	err = lambda()
+// LockAutoRenew is like Lock, but the lock is refreshed to the ttl in background (every
+// `LockRenewalFraction` of it) while the lambda runs, so the ttl only bounds a crashed holder.
+// The ctx passed to the lambda is cancelled if the lock is lost, then redislock.ErrLockLost is
+// returned unless the lambda returns an error.
+func LockAutoRenew(key string, ttl time.Duration, lambda func(ctx context.Context) error, opts ...LockOpt) (err error) {
+	ctx, span := startSpan(lockOptGet(opts).Context, "cache.LockAutoRenew")
+	span.SetAttribute("cache.key", key)
+	defer func() { endSpan(span, err) }()
+
+	lock, err := locker(key).Obtain("__lock:"+key, ttl, lockOptions(opts))
 	if err != nil {
+		return errors.Wrap(err, "cache.LockAutoRenew#ObtainKey")
+	}
+	span.SetAttribute("cache.lock.fence", lock.Fence())
+
+	ctx, stop := lock.KeepAlive(ctx, ttl, time.Duration(float64(ttl)*LockRenewalFraction))
+	defer stop() // also when the lock is kept by KeepOnError, so it expires after the ttl
+	return runLocked("cache.LockAutoRenew", lockOptGet(opts), func() error {
+		err := lambda(ctx)
+		if err == nil && ctx.Err() != nil {
+			return errors.Wrap(redislock.ErrLockLost, "cache.LockAutoRenew")
+		}
 		return err
+	}, func() error {
+		lost := ctx.Err() != nil
+		stop()
+		if lost {
+			return nil
+		}
+		return lock.Release()
+	})
+}
+
+// LockReentrant is like Lock, but the same owner (e.g. the ID of a request or a job) can lock
+// the key again in the nested code paths, the lock is released when the outermost lambda returns.
+// It is always obtained on the node of the key, regardless of `LockBackend`.
+// `LockOpt.Purpose` is not recorded for it.
+func LockReentrant(key, owner string, maxTTL time.Duration, lambda func() error, opts ...LockOpt) error {
+	lock, err := GetReentrantLock(key, owner, maxTTL)
+	if err != nil {
+		return errors.Wrap(err, "cache.LockReentrant")
 	}
+	return runLocked("cache.LockReentrant", lockOptGet(opts), lambda, lock.Release)
+}
+
+func GetReentrantLock(key, owner string, ttl time.Duration) (*redislock.ReentrantLock, error) {
+	locker := redislock.New(cliFor("__lock:" + key))
+	lock, err := locker.ObtainReentrant("__lock:"+key, owner, ttl, nil)
+	return lock, errors.Wrap(err, "cache.GetReentrantLock#ObtainKey")
+}
+
+// LockRead runs the lambda under a read hold of the key, which is shared with the other readers.
+// The writes of the key with `UnderLocking` wait for it, while the reads do not.
+// `LockOpt.Purpose` is not recorded for the read holds.
+func LockRead(key string, maxTTL time.Duration, lambda func() error, opts ...LockOpt) error {
+	lock, err := GetRWLock(key).RLock(maxTTL, nil)
+	if err != nil {
+		return errors.Wrap(err, "cache.LockRead#ObtainKey")
+	}
+	return runLocked("cache.LockRead", lockOptGet(opts), lambda, lock.Release)
+}
+
+// LockWrite runs the lambda under the write hold of the key, which excludes the readers
+// and the other locks of the key. The new readers wait while it is waiting for the readers.
+func LockWrite(key string, maxTTL time.Duration, lambda func() error, opts ...LockOpt) error {
+	lock, err := GetRWLock(key).Lock(maxTTL, lockOptions(opts))
+	if err != nil {
+		return errors.Wrap(err, "cache.LockWrite#ObtainKey")
+	}
+	return runLocked("cache.LockWrite", lockOptGet(opts), lambda, lock.Release)
+}
+
+// LockFair is like Lock, but it waits for the lock (up to the maxTTL) and the contenders get it
+// in their arrival order, so none of them starves under contention.
+// It is always obtained on the node of the key, regardless of `LockBackend`.
+func LockFair(key string, maxTTL time.Duration, lambda func() error, opts ...LockOpt) error {
+	opt := lockOptions(opts)
+	opt.RetryStrategy = redislock.LinearBackoff(SpinInterval)
+	opt.Notify = true
 
-	err = lock.Release()
+	locker := redislock.New(cliFor("__lock:" + key))
+	lock, err := locker.ObtainFair("__lock:"+key, maxTTL, opt)
 	if err != nil {
-		return errors.Wrap(err, "cache.Lock#ReleaseLock")
+		return errors.Wrap(err, "cache.LockFair#ObtainKey")
 	}
-	return nil
+	return runLocked("cache.LockFair", lockOptGet(opts), lambda, lock.Release)
+}
+
+// GetRWLock returns the read-write lock of the key, on the node of the key.
+func GetRWLock(key string) *redislock.RWLock {
+	return redislock.New(cliFor("__lock:" + key)).RWLock("__lock:" + key)
+}
+
+// Semaphore returns the counting semaphore of the key, on the node of the key, which allows up to
+// limit holders at the same time, each for the ttl unless refreshed.
+func Semaphore(key string, limit int, ttl time.Duration) *redislock.Semaphore {
+	return redislock.New(cliFor("__sem:"+key)).Semaphore("__sem:"+key, limit, ttl)
 }
 
-func GetLock(key string, ttl time.Duration) (*redislock.Lock, error) {
-	locker := redislock.New(Cli)
-	lock, err := locker.Obtain("__lock:"+key, ttl, nil)
+func GetLock(key string, ttl time.Duration, opts ...LockOpt) (*redislock.Lock, error) {
+	lock, err := locker(key).Obtain("__lock:"+key, ttl, lockOptions(opts))
 	return lock, errors.Wrap(err, "cache.GetLock#ObtainKey")
 }
 
-func spinning(keys []string, opt Opt) error {
+// runLocked runs the lambda, then releases the lock by the release func, also when the lambda
+// panics (then the panic is re-raised). The lock is kept if the lambda fails and `KeepOnError`.
+func runLocked(op string, opt LockOpt, lambda func() error, release func() error) (err error) {
+	returned := false
+	defer func() {
+		if !returned {
+			if !opt.KeepOnError {
+				_ = release()
+			}
+			if r := recover(); r != nil {
+				panic(r)
+			}
+		}
+	}()
+
+	err = lambda()
+	returned = true
+	if err != nil && opt.KeepOnError {
+		return err
+	}
+
+	releaseErr := release()
+	if err == nil {
+		return errors.Wrap(releaseErr, op+"#ReleaseLock")
+	} else if releaseErr != nil {
+		return errors.Wrapf(err, "%s#ReleaseLock: %v, after", op, releaseErr)
+	}
+	return err
+}
+
+func locker(key string) redislock.Locker {
+	if LockBackend != nil {
+		return LockBackend
+	}
+	return redislock.New(cliFor("__lock:" + key))
+}
+
+// spinning waits while the keys are locked: by the writers (and the plain locks) for the reads,
+// by anyone (including the readers of the RWLocks) otherwise.
+// It wakes up on the release notifications of the locks, and re-checks every `SpinInterval`
+// for the expirations.
+func spinning(keys []string, opt Opt, read bool) error {
 	if !opt.UnderLocking {
 		return nil
 	}
 
-	k := []string{}
+	locks, k := []string{}, []string{}
 	for _, key := range keys {
+		locks = append(locks, "__lock:"+key)
 		k = append(k, "__lock:"+key)
+		if !read {
+			k = append(k, redislock.ReadersKey("__lock:"+key))
+		}
 	}
-	result, err := Cli.Exists(k...).Result()
-	if err != nil {
+	locked, err := isLocked(k)
+	if err != nil || !locked {
 		return err
 	}
-	if result == int64(0) { // no lock
-		return nil
-	}
-
 	if opt.FailIfLocked {
 		return errors.New("cache.spinning: under locking")
 	}
-	time.Sleep(100 * time.Millisecond)
-	return spinning(keys, opt)
+	incMetric(MetricLockWaits, nil)
+	defer func(start time.Time) { observeMetric(MetricLockWaitSeconds, nil, time.Since(start)) }(time.Now())
+
+	wake, stop := releaseNotifications(locks)
+	defer stop()
+
+	var timeout <-chan time.Time
+	if opt.WaitTimeout > 0 {
+		timer := time.NewTimer(opt.WaitTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	retry := redislock.LinearBackoff(SpinInterval)
+	if opt.RetryStrategy != nil {
+		retry = opt.RetryStrategy()
+	}
+	var recheck *time.Timer
+
+	for {
+		// subscribed before checking, so a release in between is not missed
+		locked, err = isLocked(k)
+		if err != nil || !locked {
+			return err
+		}
+
+		backoff := retry.NextBackoff()
+		if backoff < 1 {
+			return errors.Wrap(ErrWaitTimeout, "cache.spinning")
+		}
+		if recheck == nil {
+			recheck = time.NewTimer(backoff)
+			defer recheck.Stop()
+		} else {
+			if !recheck.Stop() {
+				select {
+				case <-recheck.C:
+				default:
+				}
+			}
+			recheck.Reset(backoff)
+		}
+
+		select {
+		case <-wake:
+		case <-recheck.C:
+		case <-timeout:
+			return errors.Wrap(ErrWaitTimeout, "cache.spinning")
+		}
+	}
+}
+
+// KEYS: lock keys (the read holds of the RWLocks are counted while not expired)
+// ARGV: now (ms)
+var luaLocked = redis.NewScript(`
+local n = 0
+for _, key in ipairs(KEYS) do
+	local kind = redis.call("type", key).ok
+	if kind == "zset" then
+		n = n + redis.call("zcount", key, "(" .. ARGV[1], "+inf")
+	elseif kind ~= "none" then
+		n = n + 1
+	end
+end
+return n`)
+
+func isLocked(lockKeys []string) (bool, error) {
+	var result int64
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	err := forEachBatch(lockKeys, func(cli redis.UniversalClient, k []string) error {
+		n, err := luaLocked.Run(cli, k, now).Int64()
+		result += n
+		return err
+	})
+	return result > 0, err
+}
+
+// releaseNotifications subscribes to the release channels of the locks on their nodes.
+func releaseNotifications(lockKeys []string) (<-chan *redis.Message, func()) {
+	wake := make(chan *redis.Message, 1)
+	stops := []func(){}
+	_ = forEachBatch(lockKeys, func(cli redis.UniversalClient, k []string) error {
+		msgs, stop := redislock.Notifications(cli, k...)
+		stops = append(stops, stop)
+		if msgs == nil {
+			return nil
+		}
+		go func() {
+			for msg := range msgs {
+				select {
+				case wake <- msg:
+				default:
+				}
+			}
+		}()
+		return nil
+	})
+
+	return wake, func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}
 }
@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/go-web-kits/cache/redislock"
+	"github.com/pkg/errors"
+)
+
+// LockOpt is the options of the locks.
+type LockOpt struct {
+	// Purpose is recorded in the owner of the lock (with the host & pid), see `ListLocks`.
+	Purpose string
+	// KeepOnError keeps the lock held (until its ttl) when the lambda fails or panics,
+	// e.g. to stop the retries of a failed job for a while.
+	KeepOnError bool
+	// Fence counts the fencing token of the lock (`Lock.Fence()`, see `Opt.Fence`), which keeps
+	// a counter key per locked key.
+	Fence bool
+	// Context carries the parent span of the lock, see `Tracing`.
+	Context context.Context
+}
+
+// LockOwner is recorded in the metadata of the locks obtained by this package.
+type LockOwner struct {
+	Host    string    `json:"host"`
+	PID     int       `json:"pid"`
+	Purpose string    `json:"purpose,omitempty"`
+	Since   time.Time `json:"since"`
+}
+
+// LockInfo describes a held lock.
+type LockInfo struct {
+	Key string
+	// Owner is nil if unknown, e.g. the reentrant locks, or the locks obtained by redislock directly.
+	Owner *LockOwner
+	TTL   time.Duration
+}
+
+var hostname, _ = os.Hostname()
+
+// ListLocks lists the held locks whose keys (without the `__lock:` prefix) match the pattern,
+// scanning every node like `DeleteMatched`.
+func ListLocks(pattern string) ([]LockInfo, error) {
+	clients, err := nodes()
+	if err != nil {
+		return nil, errors.Wrap(err, "cache.ListLocks#Nodes")
+	}
+
+	result := []LockInfo{}
+	for _, client := range clients {
+		var cursor uint64
+		for {
+			keys, next, err := client.Scan(cursor, "__lock:"+pattern, DefaultScanCount).Result()
+			if err != nil {
+				return nil, errors.Wrap(err, "cache.ListLocks#Scan")
+			}
+
+			infos, err := lockInfos(client, keys)
+			if err != nil {
+				return nil, errors.Wrap(err, "cache.ListLocks")
+			}
+			result = append(result, infos...)
+
+			if next == 0 {
+				break
+			}
+			cursor = next
+		}
+	}
+	return result, nil
+}
+
+// ForceUnlock breaks the lock of the key regardless of its holder (e.g. a dead process),
+// and logs an audit entry with the reason and the previous owner to `CommandLogger` (at WARN).
+func ForceUnlock(key, reason string) error {
+	var value string
+	var err error
+	if redlock, ok := LockBackend.(*redislock.Redlock); ok {
+		value, err = redlock.ForceRelease("__lock:" + key)
+	} else {
+		value, err = redislock.New(cliFor("__lock:" + key)).ForceRelease("__lock:" + key)
+	}
+	if err != nil {
+		return errors.Wrap(err, "cache.ForceUnlock")
+	}
+
+	_, metadata := redislock.ParseValue(value)
+	// an audit entry, regardless of UnLog
+	CommandLogger.LogAttrs(context.Background(), slog.LevelWarn, "FORCE UNLOCK", slog.String("args", key),
+		slog.String("key", key), slog.String("reason", reason), slog.Any("owner", parseLockOwner(metadata)))
+	return nil
+}
+
+func lockInfos(client redis.UniversalClient, keys []string) ([]LockInfo, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	pipe := client.Pipeline()
+	gets := make([]*redis.StringCmd, len(keys))
+	pttls := make([]*redis.DurationCmd, len(keys))
+	for i, key := range keys {
+		gets[i], pttls[i] = pipe.Get(key), pipe.PTTL(key)
+	}
+	// the errors are checked by the commands, e.g. GET fails on the reentrant locks (hashes)
+	_, _ = pipe.Exec()
+
+	infos := make([]LockInfo, 0, len(keys))
+	for i, key := range keys {
+		ttl, err := pttls[i].Result()
+		if err != nil {
+			return nil, err
+		} else if ttl == -2 { // released meanwhile
+			continue
+		}
+
+		info := LockInfo{Key: strings.TrimPrefix(key, "__lock:"), TTL: ttl}
+		if value, err := gets[i].Result(); err == nil {
+			_, metadata := redislock.ParseValue(value)
+			info.Owner = parseLockOwner(metadata)
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func lockOptions(opts []LockOpt) *redislock.Options {
+	opt := lockOptGet(opts)
+	metadata, _ := json.Marshal(LockOwner{Host: hostname, PID: os.Getpid(), Purpose: opt.Purpose, Since: time.Now()})
+	return &redislock.Options{Metadata: string(metadata), Fence: opt.Fence}
+}
+
+func parseLockOwner(metadata string) *LockOwner {
+	var owner LockOwner
+	if json.Unmarshal([]byte(metadata), &owner) != nil {
+		return nil
+	}
+	return &owner
+}
@@ -0,0 +1,269 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// CommandLogger receives a record per command seen by `Hook`, with the attributes:
+// cmd, keys, args, duration, size (bytes of the string results), slow and error, plus pipeline=true
+// for the commands in a pipeline, which is followed by a summary record (cmd=pipeline) with the
+// count of the commands and the errors.
+// Default is the colored text output through `Logger` (see `ColorHandler`).
+var CommandLogger = slog.New(ColorHandler{})
+
+// SlowThreshold is the duration from which the commands are logged at the WARN level (with slow=true),
+// 0 means disabled.
+var SlowThreshold time.Duration
+
+// LogOnlySlow skips the logs of the commands faster than `SlowThreshold` (except the errors).
+var LogOnlySlow = false
+
+// MaxLogArgLen truncates each argument in the logs to the bytes, 0 means no limit.
+var MaxLogArgLen = 0
+
+// RedactPatterns are the key patterns (see `path.Match`, e.g. `session:*`) whose values are
+// replaced by [REDACTED] in the logs. Any argument is matched, not only the keys, so all the
+// arguments of a command touching a matched key are redacted, whatever its key positions.
+var RedactPatterns []string
+
+// LogSampling is the share of the commands logged by their names, e.g. `{"get": 0.01}`
+// logs 1% of the GETs. The errors and the slow commands are always logged.
+var LogSampling map[string]float64
+
+// UseSlog sends the records of the commands to the logger, e.g. `slog.New(slog.NewJSONHandler(os.Stdout, nil))`.
+func UseSlog(logger *slog.Logger) {
+	CommandLogger = logger
+}
+
+// ColorHandler is the slog.Handler of the colored text output, through `Logger`.
+type ColorHandler struct{}
+
+func (ColorHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+// Handle outputs the level (unless INFO), the args and the duration of the commands with
+// slow=true and the error if any, or the other attrs of the records without a duration
+// (e.g. the audit of `ForceUnlock`). `UnLog` is checked by the callers.
+func (ColorHandler) Handle(_ context.Context, r slog.Record) error {
+	var content string
+	var arg interface{}
+	attrs := map[string]interface{}{}
+	r.Attrs(func(a slog.Attr) bool {
+		switch a.Key {
+		case "args":
+			content = a.Value.String()
+		case "duration":
+			arg = a.Value.Duration()
+		default:
+			attrs[a.Key] = a.Value.Any()
+		}
+		return true
+	})
+
+	op := r.Message
+	if r.Level != slog.LevelInfo {
+		op = r.Level.String() + " " + op
+	}
+	if arg == nil {
+		arg = attrs
+	} else if slow, err := attrs["slow"], attrs["error"]; slow != nil || err != nil {
+		line := fmt.Sprint(arg)
+		if slow != nil {
+			line += fmt.Sprint(" slow=", slow)
+		}
+		if err != nil {
+			line += fmt.Sprintf(" error=%q", err)
+		}
+		arg = line
+	}
+	output(op, content, arg)
+	return nil
+}
+
+func (h ColorHandler) WithAttrs([]slog.Attr) slog.Handler {
+	return h
+}
+
+func (h ColorHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+func logCommand(ctx context.Context, cmd redis.Cmder, duration time.Duration, extra ...slog.Attr) {
+	err := filtered(cmd.Err())
+	logRecord(ctx, cmd.Name(), err, duration, func() []slog.Attr {
+		return append([]slog.Attr{
+			slog.String("cmd", cmd.Name()),
+			slog.Any("keys", cmdKeys(cmd)),
+			slog.String("args", cmdContent(cmd)),
+			slog.Duration("duration", duration),
+			slog.Int("size", resultSize(cmd)),
+		}, extra...)
+	})
+}
+
+// logPipeline logs the summary of a pipeline: the commands, and the count of the commands & the errors.
+func logPipeline(ctx context.Context, cmds []redis.Cmder, duration time.Duration) {
+	names := make([]string, len(cmds))
+	errs := 0
+	var err error
+	for i, cmd := range cmds {
+		names[i] = cmd.Name()
+		if e := filtered(cmd.Err()); e != nil {
+			errs++
+			if err == nil {
+				err = e
+			}
+		}
+	}
+
+	logRecord(ctx, "pipeline", err, duration, func() []slog.Attr {
+		return []slog.Attr{
+			slog.String("cmd", "pipeline"),
+			slog.String("args", truncated(strings.Join(names, " "))),
+			slog.Duration("duration", duration),
+			slog.Int("commands", len(cmds)),
+			slog.Int("errors", errs),
+		}
+	})
+}
+
+// logRecord logs the record of the attrs by the rules of `SlowThreshold`, `LogOnlySlow` & `LogSampling`.
+func logRecord(ctx context.Context, name string, err error, duration time.Duration, attrsOf func() []slog.Attr) {
+	if UnLog {
+		return
+	}
+
+	slow := SlowThreshold > 0 && duration >= SlowThreshold
+	if err == nil && !slow && (LogOnlySlow && SlowThreshold > 0 || !sampled(name)) {
+		return
+	}
+
+	attrs := attrsOf()
+	level := slog.LevelInfo
+	if slow {
+		attrs = append(attrs, slog.Bool("slow", true))
+		level = slog.LevelWarn
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+		level = slog.LevelError
+	}
+	CommandLogger.LogAttrs(ctx, level, strings.ToUpper(name), attrs...)
+}
+
+func sampled(name string) bool {
+	rate, ok := LogSampling[name]
+	return !ok || rand.Float64() < rate
+}
+
+func redacted(cmd redis.Cmder) bool {
+	for _, pattern := range RedactPatterns {
+		for _, arg := range cmd.Args()[1:] {
+			if ok, _ := path.Match(pattern, fmt.Sprint(arg)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func truncated(arg string) string {
+	if MaxLogArgLen > 0 && len(arg) > MaxLogArgLen {
+		return fmt.Sprintf("%s...(%d bytes)", arg[:MaxLogArgLen], len(arg))
+	}
+	return arg
+}
+
+func cmdContent(cmd redis.Cmder) string {
+	if redacted(cmd) {
+		return strings.Join(cmdKeys(cmd), " ") + " [REDACTED]"
+	}
+
+	vals := []string{}
+	for _, v := range cmd.Args()[1:] {
+		vals = append(vals, truncated(fmt.Sprint(v)))
+	}
+
+	switch cmd.Name() {
+	case "set", "setnx", "incrby", "decrby":
+		return vals[0] + ":: " + strings.Join(vals[1:], ", ")
+	default:
+		return strings.Join(vals, " ")
+	}
+}
+
+func cmdKeys(cmd redis.Cmder) []string {
+	args := cmd.Args()
+	var keys []interface{}
+	switch cmd.Name() {
+	case "mget", "del", "unlink", "exists", "touch", "watch", "sinter", "sunion", "sdiff",
+		"sinterstore", "sunionstore", "sdiffstore", "pfcount", "pfmerge":
+		keys = args[1:]
+	case "mset", "msetnx":
+		for i := 1; i < len(args); i += 2 {
+			keys = append(keys, args[i])
+		}
+	case "rename", "renamenx", "rpoplpush", "smove", "lmove", "copy":
+		if len(args) > 2 {
+			keys = args[1:3]
+		}
+	case "blpop", "brpop", "bzpopmin", "bzpopmax":
+		if len(args) > 2 {
+			keys = args[1 : len(args)-1]
+		}
+	case "eval", "evalsha":
+		if len(args) > 2 {
+			n, _ := args[2].(int)
+			if 3+n <= len(args) {
+				keys = args[3 : 3+n]
+			}
+		}
+	case "ping", "scan", "publish", "subscribe", "script", "info", "select":
+	default:
+		if len(args) > 1 {
+			keys = args[1:2]
+		}
+	}
+
+	result := make([]string, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, fmt.Sprint(key))
+	}
+	return result
+}
+
+// resultSize returns the bytes of the string results of the command.
+func resultSize(cmd redis.Cmder) int {
+	switch cmd := cmd.(type) {
+	case *redis.StringCmd:
+		return len(cmd.Val())
+	case *redis.StringSliceCmd:
+		size := 0
+		for _, v := range cmd.Val() {
+			size += len(v)
+		}
+		return size
+	case *redis.SliceCmd:
+		size := 0
+		for _, v := range cmd.Val() {
+			if s, ok := v.(string); ok {
+				size += len(s)
+			}
+		}
+		return size
+	case *redis.Cmd:
+		if s, ok := cmd.Val().(string); ok {
+			return len(s)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,221 @@
+package cache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The metrics fed to `Metrics`.
+const (
+	MetricHits            = "cache_hits_total"
+	MetricMisses          = "cache_misses_total"
+	MetricSets            = "cache_sets_total"
+	MetricLoads           = "cache_loader_calls_total"
+	MetricLoadErrors      = "cache_loader_errors_total"
+	MetricLoadDuration    = "cache_loader_duration_seconds"
+	MetricLockWaits       = "cache_lock_waits_total"
+	MetricLockWaitSeconds = "cache_lock_wait_seconds"
+	MetricCommandDuration = "cache_command_duration_seconds" // labelled by cmd
+	MetricCommandErrors   = "cache_command_errors_total"     // labelled by cmd
+)
+
+// DefaultBuckets are the upper bounds (in seconds) of the histograms of MemoryMetrics.
+var DefaultBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// Metrics receives the metrics of the operations & the commands, default (nil) is disabled.
+var Metrics MetricsSink
+
+// Labels are the labels of a metric.
+type Labels map[string]string
+
+// MetricsSink receives the metrics: the counters are increased by 1, and the durations
+// are observed into histograms.
+type MetricsSink interface {
+	Inc(name string, labels Labels)
+	Observe(name string, labels Labels, d time.Duration)
+}
+
+// MetricsSnapshot is a copy of the metrics of MemoryMetrics, keyed by the series,
+// e.g. `cache_hits_total` or `cache_command_duration_seconds{cmd="get"}`.
+type MetricsSnapshot struct {
+	Counters   map[string]int64
+	Histograms map[string]HistogramSnapshot
+}
+
+// HistogramSnapshot is a copy of a histogram, Buckets are the cumulative counts of DefaultBuckets.
+type HistogramSnapshot struct {
+	Count   int64
+	Sum     time.Duration
+	Buckets []int64
+}
+
+// MemoryMetrics keeps the metrics in memory, see `Snapshot`.
+type MemoryMetrics struct {
+	mu         sync.Mutex
+	series     map[string]series
+	counters   map[string]int64
+	histograms map[string]*HistogramSnapshot
+}
+
+type series struct {
+	name   string
+	labels Labels
+}
+
+// NewMemoryMetrics returns an empty MemoryMetrics.
+func NewMemoryMetrics() *MemoryMetrics {
+	return &MemoryMetrics{
+		series:     map[string]series{},
+		counters:   map[string]int64{},
+		histograms: map[string]*HistogramSnapshot{},
+	}
+}
+
+func (m *MemoryMetrics) Inc(name string, labels Labels) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counters[m.key(name, labels)]++
+}
+
+func (m *MemoryMetrics) Observe(name string, labels Labels, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := m.key(name, labels)
+	h := m.histograms[key]
+	if h == nil {
+		h = &HistogramSnapshot{Buckets: make([]int64, len(DefaultBuckets))}
+		m.histograms[key] = h
+	}
+	h.Count++
+	h.Sum += d
+	for i, bound := range DefaultBuckets {
+		if d.Seconds() <= bound {
+			h.Buckets[i]++
+		}
+	}
+}
+
+// Snapshot returns a copy of the metrics.
+func (m *MemoryMetrics) Snapshot() MetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := MetricsSnapshot{Counters: map[string]int64{}, Histograms: map[string]HistogramSnapshot{}}
+	for key, n := range m.counters {
+		snapshot.Counters[key] = n
+	}
+	for key, h := range m.histograms {
+		copied := *h
+		copied.Buckets = append([]int64{}, h.Buckets...)
+		snapshot.Histograms[key] = copied
+	}
+	return snapshot
+}
+
+// key returns the key of the series, and records it.
+func (m *MemoryMetrics) key(name string, labels Labels) string {
+	key := name + formatLabels(labels, "")
+	if _, ok := m.series[key]; !ok {
+		m.series[key] = series{name: name, labels: labels}
+	}
+	return key
+}
+
+// PrometheusMetrics is a MemoryMetrics exposed in the Prometheus text format, e.g.
+//
+//	http.Handle("/metrics", metrics)
+type PrometheusMetrics struct {
+	*MemoryMetrics
+}
+
+// NewPrometheusMetrics returns an empty PrometheusMetrics.
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return &PrometheusMetrics{MemoryMetrics: NewMemoryMetrics()}
+}
+
+// WriteTo writes the metrics in the Prometheus text format, the series grouped by their metrics.
+func (m *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	names := []string{}
+	keys := map[string][]string{}
+	for key, s := range m.series {
+		if _, ok := keys[s.name]; !ok {
+			names = append(names, s.name)
+		}
+		keys[s.name] = append(keys[s.name], key)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		sort.Strings(keys[name])
+		for i, key := range keys[name] {
+			s := m.series[key]
+			if n, ok := m.counters[key]; ok {
+				if i == 0 {
+					fmt.Fprintf(&b, "# TYPE %s counter\n", name)
+				}
+				fmt.Fprintf(&b, "%s %d\n", key, n)
+			} else if h, ok := m.histograms[key]; ok {
+				if i == 0 {
+					fmt.Fprintf(&b, "# TYPE %s histogram\n", name)
+				}
+				for j, bound := range DefaultBuckets {
+					le := strconv.FormatFloat(bound, 'g', -1, 64)
+					fmt.Fprintf(&b, "%s_bucket%s %d\n", name, formatLabels(s.labels, le), h.Buckets[j])
+				}
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", name, formatLabels(s.labels, "+Inf"), h.Count)
+				fmt.Fprintf(&b, "%s_sum%s %g\n", name, formatLabels(s.labels, ""), h.Sum.Seconds())
+				fmt.Fprintf(&b, "%s_count%s %d\n", name, formatLabels(s.labels, ""), h.Count)
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = m.WriteTo(w)
+}
+
+// labelEscaper escapes the label values like the Prometheus text format, which only knows these escapes.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels formats the labels (and the le label of a bucket if given) like `{a="1",b="2"}`.
+func formatLabels(labels Labels, le string) string {
+	pairs := make([]string, 0, len(labels)+1)
+	for k, v := range labels {
+		pairs = append(pairs, k+`="`+labelEscaper.Replace(v)+`"`)
+	}
+	sort.Strings(pairs)
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func incMetric(name string, labels Labels) {
+	if Metrics != nil {
+		Metrics.Inc(name, labels)
+	}
+}
+
+func observeMetric(name string, labels Labels, d time.Duration) {
+	if Metrics != nil {
+		Metrics.Observe(name, labels, d)
+	}
+}
@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const namespacePrefix = "__ns:"
+
+// NamespaceGenerationTTL is how long a resolved generation is cached locally,
+// so a `Flush` from another process is seen after at most this duration.
+var NamespaceGenerationTTL = time.Second
+
+// NamespaceExpiresIn is used for writes through a namespace when `Opt.ExpiresIn`
+// is not given, so that the keys of flushed generations age out.
+var NamespaceExpiresIn = 24 * time.Hour
+
+// NamespaceHandle scopes keys under a generation counter stored in Redis:
+// "products" + "key1" => "products:<generation>:key1"
+type NamespaceHandle struct {
+	name string
+
+	mu    sync.Mutex
+	gen   int64
+	genAt time.Time
+}
+
+var namespaces sync.Map
+
+// Namespace returns the handle of the namespace, which is shared by the process.
+//
+//	cache.Namespace("products").Set("1", product)
+//	cache.Namespace("products").Flush() // => all the keys above are gone
+func Namespace(name string) *NamespaceHandle {
+	ns, _ := namespaces.LoadOrStore(name, &NamespaceHandle{name: name})
+	return ns.(*NamespaceHandle)
+}
+
+func (ns *NamespaceHandle) Name() string {
+	return ns.name
+}
+
+// Generation returns the current generation, 0 if the namespace has never been flushed.
+func (ns *NamespaceHandle) Generation() (int64, error) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	if !ns.genAt.IsZero() && time.Since(ns.genAt) < NamespaceGenerationTTL {
+		return ns.gen, nil
+	}
+
+	gen, err := cliFor(namespacePrefix + ns.name).Get(namespacePrefix + ns.name).Int64()
+	if err != nil && !IsKeyNotFound(err) {
+		return 0, errors.Wrap(err, "cache.Namespace#Generation")
+	}
+	ns.gen, ns.genAt = gen, time.Now()
+	return gen, nil
+}
+
+// Key resolves the key under the current generation.
+func (ns *NamespaceHandle) Key(key string) (string, error) {
+	gen, err := ns.Generation()
+	if err != nil {
+		return "", err
+	}
+	return ns.name + ":" + strconv.FormatInt(gen, 10) + ":" + key, nil
+}
+
+// Flush invalidates every key of the namespace in O(1) by bumping the generation.
+func (ns *NamespaceHandle) Flush() error {
+	gen, err := cliFor(namespacePrefix + ns.name).Incr(namespacePrefix + ns.name).Result()
+	if err != nil {
+		return errors.Wrap(err, "cache.Namespace#Flush")
+	}
+
+	ns.mu.Lock()
+	ns.gen, ns.genAt = gen, time.Now()
+	ns.mu.Unlock()
+	return nil
+}
+
+func (ns *NamespaceHandle) Get(key string, opts ...Opt) (interface{}, error) {
+	k, err := ns.Key(key)
+	if err != nil {
+		return nil, err
+	}
+	return Get(k, opts...)
+}
+
+func (ns *NamespaceHandle) Set(key string, value interface{}, opts ...Opt) error {
+	k, err := ns.Key(key)
+	if err != nil {
+		return err
+	}
+	return Set(k, value, ns.opt(opts))
+}
+
+func (ns *NamespaceHandle) Fetch(key string, opts ...Opt) (interface{}, error) {
+	k, err := ns.Key(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts) == 0 {
+		return Fetch(k)
+	}
+	return Fetch(k, ns.opt(opts))
+}
+
+func (ns *NamespaceHandle) Delete(keys ...string) error {
+	ks := make([]string, 0, len(keys))
+	for _, key := range keys {
+		k, err := ns.Key(key)
+		if err != nil {
+			return err
+		}
+		ks = append(ks, k)
+	}
+	return Delete(ks...)
+}
+
+func (ns *NamespaceHandle) opt(opts []Opt) Opt {
+	opt := optGet(opts)
+	if opt.ExpiresIn == 0 {
+		opt.ExpiresIn = NamespaceExpiresIn
+	}
+	return opt
+}
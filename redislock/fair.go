@@ -0,0 +1,69 @@
+package redislock
+
+import (
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// DefaultQueueTTL is the default of Options.QueueTTL.
+const DefaultQueueTTL = 5 * time.Second
+
+// The contenders of a fair lock are queued in a sorted set of {token: arrival sequence},
+// with their expirations in another sorted set of {token: expiration (ms)}.
+var (
+	// KEYS: key, queue, timeouts, sequence, fence
+	// ARGV: token, ttl (ms), now (ms), queue ttl (ms), "1" to increment the fence
+	luaFairObtain = redis.NewScript(`
+for _, token in ipairs(redis.call("zrangebyscore", KEYS[3], "-inf", ARGV[3])) do
+	redis.call("zrem", KEYS[2], token)
+	redis.call("zrem", KEYS[3], token)
+end
+
+if not redis.call("zscore", KEYS[2], ARGV[1]) then
+	redis.call("zadd", KEYS[2], redis.call("incr", KEYS[4]), ARGV[1])
+end
+redis.call("zadd", KEYS[3], tonumber(ARGV[3]) + tonumber(ARGV[4]), ARGV[1])
+for i = 2, 4 do
+	redis.call("pexpire", KEYS[i], ARGV[4])
+end
+
+if redis.call("zrange", KEYS[2], 0, 0)[1] == ARGV[1] and redis.call("set", KEYS[1], ARGV[1], "nx", "px", ARGV[2]) then
+	redis.call("zrem", KEYS[2], ARGV[1])
+	redis.call("zrem", KEYS[3], ARGV[1])
+	if ARGV[5] == "1" then
+		return redis.call("incr", KEYS[5])
+	end
+	return 1
+end
+return 0`)
+	luaFairDequeue = redis.NewScript(`redis.call("zrem", KEYS[2], ARGV[1]) return redis.call("zrem", KEYS[1], ARGV[1])`)
+)
+
+// ObtainFair tries to obtain the lock like Obtain, but the contenders get it in their arrival
+// order. A contender not retrying within Options.QueueTTL (e.g. crashed) is skipped, so the
+// backoffs of the retry strategy should be shorter than it.
+// May return ErrNotObtained if not successful.
+func (c *Client) ObtainFair(key string, ttl time.Duration, opt *Options) (*Lock, error) {
+	token, err := c.randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	wake, stop := c.notifications(opt, key)
+	defer stop()
+
+	lock := &Lock{client: c, key: key, value: token + opt.getMetadata()}
+	queue, timeouts := siblingKey(key, "queue"), siblingKey(key, "queue:timeouts")
+	keys := []string{key, queue, timeouts, siblingKey(key, "queue:seq"), siblingKey(key, "fence")}
+	err = attempt(ttl, opt, wake, func() (ok bool, err error) {
+		lock.fence, err = luaFairObtain.Run(c.client, keys, lock.value,
+			milliseconds(ttl), milliseconds(sinceEpoch()), milliseconds(opt.getQueueTTL()), boolArg(opt.getFence())).Int64()
+		return lock.fence > 0, err
+	})
+	if err != nil {
+		_ = luaFairDequeue.Run(c.client, []string{queue, timeouts}, lock.value).Err()
+		return nil, err
+	}
+	return lock.fenced(opt), nil
+}
@@ -0,0 +1,46 @@
+package redislock
+
+import (
+	"github.com/go-redis/redis/v7"
+)
+
+// Subscriber is implemented by the redis clients supporting pub/sub, which is required by
+// Options.Notify.
+type Subscriber interface {
+	Subscribe(channels ...string) *redis.PubSub
+}
+
+// ReleaseChannel returns the pub/sub channel on which the releases of the lock key are published.
+func ReleaseChannel(key string) string {
+	return "__released:" + key
+}
+
+// Notifications subscribes to the release channels of the keys, the returned channel receives
+// when any of them is released, until the returned func is called.
+// The returned channel is nil (blocks forever) if the client does not support pub/sub.
+func Notifications(client RedisClient, keys ...string) (<-chan *redis.Message, func()) {
+	sub, ok := client.(Subscriber)
+	if !ok {
+		return nil, func() {}
+	}
+
+	channels := make([]string, 0, len(keys))
+	for _, key := range keys {
+		channels = append(channels, ReleaseChannel(key))
+	}
+
+	pubsub := sub.Subscribe(channels...)
+	// waits for the confirmation, so that no release after this call is missed
+	if _, err := pubsub.Receive(); err != nil {
+		_ = pubsub.Close()
+		return nil, func() {}
+	}
+	return pubsub.Channel(), func() { _ = pubsub.Close() }
+}
+
+func (c *Client) notifications(opt *Options, key string) (<-chan *redis.Message, func()) {
+	if opt == nil || !opt.Notify {
+		return nil, func() {}
+	}
+	return Notifications(c.client, key)
+}
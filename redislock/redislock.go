@@ -6,7 +6,10 @@ import (
 	"encoding/base64"
 	"errors"
 	"io"
+	mrand "math/rand"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,9 +17,29 @@ import (
 )
 
 var (
-	luaRefresh = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`)
-	luaRelease = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`)
-	luaPTTL    = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pttl", KEYS[1]) else return -3 end`)
+	// ARGV[3] is "1" to increment the fencing token (KEYS[2]), returns 1 when obtained otherwise
+	luaObtain = redis.NewScript(`
+if not redis.call("set", KEYS[1], ARGV[1], "nx", "px", ARGV[2]) then
+	return 0
+elseif ARGV[3] == "1" then
+	return redis.call("incr", KEYS[2])
+end
+return 1`)
+	luaIncrFence = redis.NewScript(`return redis.call("incr", KEYS[1])`)
+	luaRefresh   = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`)
+	luaRelease   = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then redis.call("publish", ARGV[2], KEYS[1]) return redis.call("del", KEYS[1]) else return 0 end`)
+	luaPTTL      = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pttl", KEYS[1]) else return -3 end`)
+	// returns the value of the lock ("" if it is not a plain lock), or nil if not held
+	luaForceRelease = redis.NewScript(`
+local value = ""
+if redis.call("type", KEYS[1]).ok == "string" then
+	value = redis.call("get", KEYS[1])
+end
+if redis.call("del", KEYS[1]) == 0 then
+	return false
+end
+redis.call("publish", ARGV[1], KEYS[1])
+return value`)
 )
 
 var (
@@ -25,6 +48,9 @@ var (
 
 	// ErrLockNotHeld is returned when trying to release an inactive lock.
 	ErrLockNotHeld = errors.New("redislock: lock not held")
+
+	// ErrLockLost is returned when a lock kept alive could not be refreshed before it expired.
+	ErrLockLost = errors.New("redislock: lock lost")
 )
 
 // RedisClient is a minimal client interface.
@@ -36,6 +62,11 @@ type RedisClient interface {
 	ScriptLoad(script string) *redis.StringCmd
 }
 
+// Locker is implemented by Client and Redlock.
+type Locker interface {
+	Obtain(key string, ttl time.Duration, opt *Options) (*Lock, error)
+}
+
 // Client wraps a redis client.
 type Client struct {
 	client RedisClient
@@ -57,18 +88,35 @@ func (c *Client) Obtain(key string, ttl time.Duration, opt *Options) (*Lock, err
 		return nil, err
 	}
 
-	value := token + opt.getMetadata()
+	wake, stop := c.notifications(opt, key)
+	defer stop()
+
+	lock := &Lock{client: c, key: key, value: token + opt.getMetadata()}
+	err = attempt(ttl, opt, wake, func() (ok bool, err error) {
+		lock.fence, err = c.obtain(key, lock.value, ttl, opt.getFence())
+		return lock.fence > 0, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lock.fenced(opt), nil
+}
+
+// attempt calls try until it succeeds or fails, or gives up by the retry strategy, the max wait
+// (if positive) or the context of the options, then returns ErrNotObtained.
+// A message from wake (the release notifications) retries at once instead of waiting for the backoff.
+func attempt(wait time.Duration, opt *Options, wake <-chan *redis.Message, try func() (bool, error)) error {
 	ctx := opt.getContext()
 	retry := opt.getRetryStrategy()
 
 	var timer *time.Timer
-	for deadline := time.Now().Add(ttl); time.Now().Before(deadline); {
+	for deadline := time.Now().Add(wait); wait <= 0 || time.Now().Before(deadline); {
 
-		ok, err := c.obtain(key, value, ttl)
+		ok, err := try()
 		if err != nil {
-			return nil, err
+			return err
 		} else if ok {
-			return &Lock{client: c, key: key, value: value}, nil
+			return nil
 		}
 
 		backoff := retry.NextBackoff()
@@ -85,20 +133,47 @@ func (c *Client) Obtain(key string, ttl time.Duration, opt *Options) (*Lock, err
 
 		select {
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			return ctx.Err()
+		case <-wake:
 		case <-timer.C:
 		}
 	}
 
-	return nil, ErrNotObtained
+	return ErrNotObtained
 }
 
-func (c *Client) obtain(key, value string, ttl time.Duration) (bool, error) {
-	ok, err := c.client.SetNX(key, value, ttl).Result()
+// obtain sets the lock and increments its fencing token if fence (otherwise returns 1),
+// returns 0 if the lock is held by others.
+func (c *Client) obtain(key, value string, ttl time.Duration, fence bool) (int64, error) {
+	ttlVal := strconv.FormatInt(int64(ttl/time.Millisecond), 10)
+	n, err := luaObtain.Run(c.client, []string{key, siblingKey(key, "fence")}, value, ttlVal, boolArg(fence)).Int64()
 	if err == redis.Nil {
 		err = nil
 	}
-	return ok, err
+	return n, err
+}
+
+func (c *Client) release(key, value string) (bool, error) {
+	res, err := luaRelease.Run(c.client, []string{key}, value, ReleaseChannel(key)).Result()
+	if err == redis.Nil {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	i, ok := res.(int64)
+	return ok && i == 1, nil
+}
+
+// ForceRelease releases the lock of the key regardless of its holder, e.g. when the holder
+// is known to be dead. Returns the value of the lock ("" if it is not a plain lock).
+// May return ErrLockNotHeld.
+func (c *Client) ForceRelease(key string) (string, error) {
+	value, err := luaForceRelease.Run(c.client, []string{key}, ReleaseChannel(key)).Text()
+	if err == redis.Nil {
+		return "", ErrLockNotHeld
+	}
+	return value, err
 }
 
 func (c *Client) randomToken() (string, error) {
@@ -115,13 +190,18 @@ func (c *Client) randomToken() (string, error) {
 	return base64.RawURLEncoding.EncodeToString(c.tmp), nil
 }
 
+// tokenLen is the length of the tokens of randomToken.
+const tokenLen = 22
+
 // --------------------------------------------------------------------
 
 // Lock represents an obtained, distributed lock.
 type Lock struct {
-	client *Client
-	key    string
-	value  string
+	client  *Client
+	redlock *Redlock
+	key     string
+	value   string
+	fence   int64
 }
 
 // Obtain is a short-cut for New(...).Obtain(...).
@@ -136,38 +216,80 @@ func (l *Lock) Key() string {
 
 // Token returns the token value set by the lock.
 func (l *Lock) Token() string {
-	return l.value[:22]
+	token, _ := ParseValue(l.value)
+	return token
+}
+
+// Fence returns the fencing token of the lock, which increases monotonically on each obtain
+// of the key, so a write carrying an older token than the last one seen can be rejected.
+// For a Redlock, it is counted on the first instance only, see Redlock.
+// It is 0 unless Options.Fence.
+func (l *Lock) Fence() int64 {
+	return l.fence
+}
+
+// fenced drops the placeholder fencing token (1) of a lock obtained without Options.Fence.
+func (l *Lock) fenced(opt *Options) *Lock {
+	if !opt.getFence() {
+		l.fence = 0
+	}
+	return l
 }
 
 // Metadata returns the metadata of the lock.
 func (l *Lock) Metadata() string {
-	return l.value[22:]
+	_, metadata := ParseValue(l.value)
+	return metadata
+}
+
+// ParseValue splits the value stored at the key of a lock into its token and metadata.
+func ParseValue(value string) (token, metadata string) {
+	if len(value) < tokenLen {
+		return value, ""
+	}
+	return value[:tokenLen], value[tokenLen:]
 }
 
 // TTL returns the remaining time-to-live. Returns 0 if the lock has expired.
+// For a Redlock, it is the shortest remaining TTL among the majority still holding it.
 func (l *Lock) TTL() (time.Duration, error) {
-	res, err := luaPTTL.Run(l.client.client, []string{l.key}, l.value).Result()
-	if err == redis.Nil {
-		return 0, nil
-	} else if err != nil {
-		return 0, err
+	var ttls []time.Duration
+	var lastErr error
+	for _, c := range l.clients() {
+		ttl, err := l.ttl(c)
+		if err != nil {
+			lastErr = err
+		} else if ttl > 0 {
+			ttls = append(ttls, ttl)
+		}
 	}
 
-	if num := res.(int64); num > 0 {
-		return time.Duration(num) * time.Millisecond, nil
+	if len(ttls) < l.quorum() {
+		return 0, lastErr
 	}
-	return 0, nil
+	sort.Slice(ttls, func(i, j int) bool { return ttls[i] > ttls[j] })
+	return ttls[l.quorum()-1], nil
 }
 
 // Refresh extends the lock with a new TTL.
 // May return ErrNotObtained if refresh is unsuccessful.
 func (l *Lock) Refresh(ttl time.Duration, opt *Options) error {
 	ttlVal := strconv.FormatInt(int64(ttl/time.Millisecond), 10)
-	status, err := luaRefresh.Run(l.client.client, []string{l.key}, l.value, ttlVal).Result()
-	if err != nil {
-		return err
-	} else if status == int64(1) {
+	n := 0
+	var lastErr error
+	for _, c := range l.clients() {
+		status, err := luaRefresh.Run(c.client, []string{l.key}, l.value, ttlVal).Result()
+		if err != nil {
+			lastErr = err
+		} else if status == int64(1) {
+			n++
+		}
+	}
+
+	if n >= l.quorum() {
 		return nil
+	} else if lastErr != nil {
+		return lastErr
 	}
 	return ErrNotObtained
 }
@@ -175,17 +297,97 @@ func (l *Lock) Refresh(ttl time.Duration, opt *Options) error {
 // Release manually releases the lock.
 // May return ErrLockNotHeld.
 func (l *Lock) Release() error {
-	res, err := luaRelease.Run(l.client.client, []string{l.key}, l.value).Result()
+	n := 0
+	var lastErr error
+	for _, c := range l.clients() {
+		ok, err := c.release(l.key, l.value)
+		if err != nil {
+			lastErr = err
+		} else if ok {
+			n++
+		}
+	}
+
+	if n >= l.quorum() {
+		return nil
+	} else if lastErr != nil {
+		return lastErr
+	}
+	return ErrLockNotHeld
+}
+
+// KeepAlive refreshes the lock to the ttl every interval (default ttl/3) in background, until the
+// returned cancel func is called or ctx is done. The returned context is cancelled as soon as the lock
+// is lost: taken over by another holder, or when a failed refresh leaves less than an interval of the
+// ttl, since it would expire before the next one.
+func (l *Lock) KeepAlive(ctx context.Context, ttl, interval time.Duration) (context.Context, context.CancelFunc) {
+	if interval <= 0 {
+		interval = ttl/3 + 1 // never 0, which NewTicker panics on
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		refreshed := time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			err := l.Refresh(ttl, nil)
+			if err == nil {
+				refreshed = time.Now()
+			} else if err == ErrNotObtained || time.Since(refreshed) >= ttl-interval {
+				cancel()
+				return
+			}
+		}
+	}()
+	return ctx, cancel
+}
+
+func (l *Lock) ttl(c *Client) (time.Duration, error) {
+	res, err := luaPTTL.Run(c.client, []string{l.key}, l.value).Result()
 	if err == redis.Nil {
-		return ErrLockNotHeld
+		return 0, nil
 	} else if err != nil {
-		return err
+		return 0, err
+	}
+
+	if num := res.(int64); num > 0 {
+		return time.Duration(num) * time.Millisecond, nil
+	}
+	return 0, nil
+}
+
+func (l *Lock) clients() []*Client {
+	if l.redlock != nil {
+		return l.redlock.clients
+	}
+	return []*Client{l.client}
+}
+
+func (l *Lock) quorum() int {
+	if l.redlock != nil {
+		return l.redlock.quorum()
 	}
+	return 1
+}
 
-	if i, ok := res.(int64); !ok || i != 1 {
-		return ErrLockNotHeld
+// siblingKey returns a key derived from the key, which is on the same cluster slot.
+// It starts with the hash tag, so it never looks like a lock key (e.g. `__lock:{u}:x:fence`
+// would be the lock of the key `{u}:x:fence`): `{u}:__lockmeta:__lock:{u}:x:fence`, or
+// `{__lock:x}:fence` for a key without hash tag.
+func siblingKey(key, name string) string {
+	if s := strings.IndexByte(key, '{'); s > -1 {
+		if e := strings.IndexByte(key[s+1:], '}'); e > 0 {
+			return key[s:s+e+2] + ":__lockmeta:" + key + ":" + name
+		}
 	}
-	return nil
+	return "{" + key + "}:" + name
 }
 
 // --------------------------------------------------------------------
@@ -201,6 +403,18 @@ type Options struct {
 
 	// Optional context for Obtain timeout and cancellation control.
 	Context context.Context
+
+	// Notify wakes up the retries as soon as the lock is released (by pub/sub, on a
+	// dedicated connection), the retry strategy is still the fallback, e.g. for expirations.
+	Notify bool
+
+	// Fence increments the fencing token of the key (see Lock.Fence) on each obtain. The counter
+	// is a key without expiry per locked key ({key}:fence), so it is only kept when asked for.
+	Fence bool
+
+	// QueueTTL is how long a contender of ObtainFair is kept in the queue without retrying.
+	// Default: DefaultQueueTTL
+	QueueTTL time.Duration
 }
 
 func (o *Options) getMetadata() string {
@@ -217,6 +431,17 @@ func (o *Options) getContext() context.Context {
 	return context.Background()
 }
 
+func (o *Options) getFence() bool {
+	return o != nil && o.Fence
+}
+
+func (o *Options) getQueueTTL() time.Duration {
+	if o != nil && o.QueueTTL > 0 {
+		return o.QueueTTL
+	}
+	return DefaultQueueTTL
+}
+
 func (o *Options) getRetryStrategy() RetryStrategy {
 	if o != nil && o.RetryStrategy != nil {
 		return o.RetryStrategy
@@ -289,3 +514,71 @@ func (r *exponentialBackoff) NextBackoff() time.Duration {
 		return d
 	}
 }
+
+type jitteredBackoff struct {
+	s RetryStrategy
+}
+
+// JitteredExponentialBackoff is ExponentialBackoff with each backoff randomized between its half
+// and itself, so the contenders retrying together are spread out.
+func JitteredExponentialBackoff(min, max time.Duration) RetryStrategy {
+	return &jitteredBackoff{s: ExponentialBackoff(min, max)}
+}
+
+func (r *jitteredBackoff) NextBackoff() time.Duration {
+	d := r.s.NextBackoff()
+	if d < 2 {
+		return d
+	}
+	return d/2 + time.Duration(mrand.Int63n(int64(d/2)+1))
+}
+
+type decorrelatedJitter struct {
+	base, max, last time.Duration
+}
+
+// DecorrelatedJitter strategy randomizes each backoff between the base and 3 times the previous one,
+// capped at the max (0 means no cap). The base should be positive.
+func DecorrelatedJitter(base, max time.Duration) RetryStrategy {
+	return &decorrelatedJitter{base: base, max: max, last: base}
+}
+
+func (r *decorrelatedJitter) NextBackoff() time.Duration {
+	d := r.base
+	if upper := 3 * r.last; upper > r.base {
+		d += time.Duration(mrand.Int63n(int64(upper - r.base)))
+	}
+	if r.max != 0 && d > r.max {
+		d = r.max
+	}
+	r.last = d
+	return d
+}
+
+type deadlineRetry struct {
+	s RetryStrategy
+
+	wait     time.Duration
+	deadline time.Time
+}
+
+// DeadlineRetry stops the retries of the strategy after the wait (since the first backoff),
+// the last backoff is shortened to the deadline.
+func DeadlineRetry(s RetryStrategy, wait time.Duration) RetryStrategy {
+	return &deadlineRetry{s: s, wait: wait}
+}
+
+func (r *deadlineRetry) NextBackoff() time.Duration {
+	if r.deadline.IsZero() {
+		r.deadline = time.Now().Add(r.wait)
+	}
+
+	left := time.Until(r.deadline)
+	if left <= 0 {
+		return 0
+	}
+	if d := r.s.NextBackoff(); d < left {
+		return d
+	}
+	return left
+}
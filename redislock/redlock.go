@@ -0,0 +1,112 @@
+package redislock
+
+import (
+	"time"
+)
+
+// DefaultDriftFactor is the default share of the TTL reserved for the clock drift between the instances.
+const DefaultDriftFactor = 0.01
+
+// Redlock implements the Redlock algorithm: a lock is obtained when it is set on a majority
+// of independent Redis instances within its validity time, so it survives the failover
+// of a single master.
+// The fencing tokens (Lock.Fence) are counted on the first instance, so it should persist them.
+type Redlock struct {
+	clients []*Client
+
+	// DriftFactor is the share of the TTL reserved for the clock drift, default DefaultDriftFactor.
+	DriftFactor float64
+}
+
+// NewRedlock creates a Redlock over independent (not replicated to each other) instances.
+// The client timeouts should be short compared to the lock TTLs.
+func NewRedlock(clients ...RedisClient) *Redlock {
+	r := &Redlock{DriftFactor: DefaultDriftFactor}
+	for _, client := range clients {
+		r.clients = append(r.clients, New(client))
+	}
+	return r
+}
+
+// Obtain tries to obtain the lock on a majority of the instances.
+// May return ErrNotObtained if not successful.
+func (r *Redlock) Obtain(key string, ttl time.Duration, opt *Options) (*Lock, error) {
+	if len(r.clients) == 0 {
+		return nil, ErrNotObtained
+	}
+
+	token, err := r.clients[0].randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &Lock{redlock: r, key: key, value: token + opt.getMetadata()}
+	wake, stop := r.clients[0].notifications(opt, key)
+	defer stop()
+
+	err = attempt(ttl, opt, wake, func() (ok bool, err error) {
+		lock.fence, err = r.obtain(key, lock.value, ttl, opt.getFence())
+		return lock.fence > 0, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lock.fenced(opt), nil
+}
+
+// obtain sets the lock on every instance, and keeps it only when the majority is reached
+// while the lock is still valid. Returns the fencing token if fence (otherwise 1), 0 if not obtained.
+// The fencing token is counted on the first instance only, since the greatest of independent
+// counters is not monotonic, so the lock is not obtained while the first instance is down.
+func (r *Redlock) obtain(key, value string, ttl time.Duration, fence bool) (int64, error) {
+	start := time.Now()
+	n := 0
+	for _, c := range r.clients {
+		if ok, err := c.obtain(key, value, ttl, false); err == nil && ok > 0 {
+			n++
+		}
+	}
+
+	drift := time.Duration(float64(ttl)*r.DriftFactor) + 2*time.Millisecond
+	validity := ttl - time.Since(start) - drift
+	if n < r.quorum() || validity <= 0 {
+		r.release(key, value)
+		return 0, nil
+	} else if !fence {
+		return 1, nil
+	}
+
+	token, err := luaIncrFence.Run(r.clients[0].client, []string{siblingKey(key, "fence")}).Int64()
+	if err != nil {
+		r.release(key, value)
+		return 0, err
+	}
+	return token, nil
+}
+
+func (r *Redlock) release(key, value string) {
+	for _, c := range r.clients {
+		_, _ = c.release(key, value)
+	}
+}
+
+// ForceRelease releases the lock of the key on every instance regardless of its holder,
+// returns the value of the lock on any of them.
+// May return ErrLockNotHeld if none of them holds it.
+func (r *Redlock) ForceRelease(key string) (string, error) {
+	var value string
+	lastErr := ErrLockNotHeld
+	for _, c := range r.clients {
+		v, err := c.ForceRelease(key)
+		if err == nil {
+			value, lastErr = v, nil
+		} else if lastErr != nil {
+			lastErr = err
+		}
+	}
+	return value, lastErr
+}
+
+func (r *Redlock) quorum() int {
+	return len(r.clients)/2 + 1
+}
@@ -0,0 +1,111 @@
+package redislock
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// The reentrant lock is a hash of {owner: hold count} at the lock key, so it also excludes
+// the (non-reentrant) locks of the same key, which are checked by type (hexists fails on them).
+var (
+	luaReentrantObtain = redis.NewScript(`
+local kind = redis.call("type", KEYS[1]).ok
+if kind == "none" or kind == "hash" and redis.call("hexists", KEYS[1], ARGV[1]) == 1 then
+	local n = redis.call("hincrby", KEYS[1], ARGV[1], 1)
+	if redis.call("pttl", KEYS[1]) < tonumber(ARGV[2]) then
+		redis.call("pexpire", KEYS[1], ARGV[2])
+	end
+	return n
+end
+return 0`)
+	luaReentrantRelease = redis.NewScript(`
+if redis.call("type", KEYS[1]).ok ~= "hash" or redis.call("hexists", KEYS[1], ARGV[1]) == 0 then
+	return -1
+end
+local n = redis.call("hincrby", KEYS[1], ARGV[1], -1)
+if n <= 0 then
+	redis.call("del", KEYS[1])
+	redis.call("publish", ARGV[2], KEYS[1])
+end
+return n`)
+	luaReentrantRefresh = redis.NewScript(`if redis.call("type", KEYS[1]).ok == "hash" and redis.call("hexists", KEYS[1], ARGV[1]) == 1 then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`)
+	luaReentrantPTTL    = redis.NewScript(`if redis.call("type", KEYS[1]).ok == "hash" and redis.call("hexists", KEYS[1], ARGV[1]) == 1 then return redis.call("pttl", KEYS[1]) else return -3 end`)
+	luaReentrantHolds   = redis.NewScript(`if redis.call("type", KEYS[1]).ok ~= "hash" then return 0 end return tonumber(redis.call("hget", KEYS[1], ARGV[1]) or "0")`)
+)
+
+// ReentrantLock is a lock which can be obtained again by its owner while held,
+// and is released when every hold of the owner is released.
+type ReentrantLock struct {
+	client *Client
+	key    string
+	owner  string
+}
+
+// ObtainReentrant tries to obtain (or re-obtain, as the same owner) the lock with the given TTL,
+// each successful call must be paired with a Release. A re-obtain extends the TTL, never shortens it.
+// May return ErrNotObtained if the lock is held by another owner.
+func (c *Client) ObtainReentrant(key, owner string, ttl time.Duration, opt *Options) (*ReentrantLock, error) {
+	wake, stop := c.notifications(opt, key)
+	defer stop()
+
+	ttlVal := strconv.FormatInt(int64(ttl/time.Millisecond), 10)
+	err := attempt(ttl, opt, wake, func() (bool, error) {
+		n, err := luaReentrantObtain.Run(c.client, []string{key}, owner, ttlVal).Int64()
+		return n > 0, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ReentrantLock{client: c, key: key, owner: owner}, nil
+}
+
+// Key returns the redis key used by the lock.
+func (l *ReentrantLock) Key() string {
+	return l.key
+}
+
+// Owner returns the identity of the owner.
+func (l *ReentrantLock) Owner() string {
+	return l.owner
+}
+
+// Holds returns the count of the holds of the owner, 0 if the lock is not held by it.
+func (l *ReentrantLock) Holds() (int64, error) {
+	return luaReentrantHolds.Run(l.client.client, []string{l.key}, l.owner).Int64()
+}
+
+// TTL returns the remaining time-to-live. Returns 0 if the lock is not held by the owner.
+func (l *ReentrantLock) TTL() (time.Duration, error) {
+	num, err := luaReentrantPTTL.Run(l.client.client, []string{l.key}, l.owner).Int64()
+	if err != nil || num <= 0 {
+		return 0, err
+	}
+	return time.Duration(num) * time.Millisecond, nil
+}
+
+// Refresh extends the lock with a new TTL.
+// May return ErrNotObtained if refresh is unsuccessful.
+func (l *ReentrantLock) Refresh(ttl time.Duration, opt *Options) error {
+	ttlVal := strconv.FormatInt(int64(ttl/time.Millisecond), 10)
+	status, err := luaReentrantRefresh.Run(l.client.client, []string{l.key}, l.owner, ttlVal).Result()
+	if err != nil {
+		return err
+	} else if status == int64(1) {
+		return nil
+	}
+	return ErrNotObtained
+}
+
+// Release releases one hold of the owner, the lock is released when no hold is left.
+// May return ErrLockNotHeld.
+func (l *ReentrantLock) Release() error {
+	n, err := luaReentrantRelease.Run(l.client.client, []string{l.key}, l.owner, ReleaseChannel(l.key)).Int64()
+	if err != nil {
+		return err
+	} else if n < 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
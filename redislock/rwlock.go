@@ -0,0 +1,181 @@
+package redislock
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// The write hold is a plain lock at the key (so it also excludes the other locks of the key),
+// the read holds are a sorted set of {token: expiration (ms)} at ReadersKey(key), and a writer
+// waiting for the readers leaves its token at the intent key, which stops the new readers.
+var (
+	// KEYS: key, readers, intent
+	// ARGV: token, ttl (ms), now (ms)
+	luaReadObtain = redis.NewScript(`
+if redis.call("exists", KEYS[1]) == 1 or redis.call("exists", KEYS[3]) == 1 then
+	return 0
+end
+redis.call("zremrangebyscore", KEYS[2], "-inf", ARGV[3])
+redis.call("zadd", KEYS[2], tonumber(ARGV[3]) + tonumber(ARGV[2]), ARGV[1])
+if redis.call("pttl", KEYS[2]) < tonumber(ARGV[2]) then
+	redis.call("pexpire", KEYS[2], ARGV[2])
+end
+return 1`)
+	// KEYS: key, readers, intent, fence
+	// ARGV: token, ttl (ms), now (ms), "1" to increment the fence
+	luaWriteObtain = redis.NewScript(`
+redis.call("zremrangebyscore", KEYS[2], "-inf", ARGV[3])
+if redis.call("exists", KEYS[1]) == 1 then
+	return 0
+end
+local intent = redis.call("get", KEYS[3])
+if intent and intent ~= ARGV[1] then
+	return 0
+end
+if redis.call("zcard", KEYS[2]) > 0 then
+	redis.call("set", KEYS[3], ARGV[1], "px", ARGV[2])
+	return 0
+end
+redis.call("set", KEYS[1], ARGV[1], "px", ARGV[2])
+redis.call("del", KEYS[3])
+if ARGV[4] == "1" then
+	return redis.call("incr", KEYS[4])
+end
+return 1`)
+	luaReadRelease   = redis.NewScript(`redis.call("publish", ARGV[2], KEYS[1]) return redis.call("zrem", KEYS[1], ARGV[1])`)
+	luaIntentRelease = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then redis.call("publish", ARGV[2], KEYS[1]) return redis.call("del", KEYS[1]) else return 0 end`)
+	// KEYS: holds (the readers, or the holders of a semaphore)
+	// ARGV: token, ttl (ms), now (ms)
+	luaHoldRefresh = redis.NewScript(`
+if not redis.call("zscore", KEYS[1], ARGV[1]) then
+	return 0
+end
+redis.call("zadd", KEYS[1], tonumber(ARGV[3]) + tonumber(ARGV[2]), ARGV[1])
+if redis.call("pttl", KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 1`)
+)
+
+// ReadersKey returns the key of the read holds of the lock key.
+func ReadersKey(key string) string {
+	return siblingKey(key, "readers")
+}
+
+// RWLock is a distributed read-write lock: many readers, or one writer, can hold it.
+// A waiting writer stops the new readers from obtaining it, so the writers are not starved.
+// The expirations of the read holds are based on the clocks of the clients.
+type RWLock struct {
+	client *Client
+	key    string
+}
+
+// ReadLock represents an obtained read hold of a RWLock.
+type ReadLock struct {
+	client *Client
+	key    string
+	token  string
+}
+
+// RWLock returns the read-write lock of the key.
+func (c *Client) RWLock(key string) *RWLock {
+	return &RWLock{client: c, key: key}
+}
+
+// RLock tries to obtain a read hold with the given TTL.
+// May return ErrNotObtained if the lock is held, or waited, by a writer.
+func (rw *RWLock) RLock(ttl time.Duration, opt *Options) (*ReadLock, error) {
+	token, err := rw.client.randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	wake, stop := rw.client.notifications(opt, rw.key)
+	defer stop()
+
+	keys := []string{rw.key, ReadersKey(rw.key), siblingKey(rw.key, "intent")}
+	err = attempt(ttl, opt, wake, func() (bool, error) {
+		ok, err := luaReadObtain.Run(rw.client.client, keys, token, milliseconds(ttl), milliseconds(sinceEpoch())).Int64()
+		return ok == 1, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ReadLock{client: rw.client, key: rw.key, token: token}, nil
+}
+
+// Lock tries to obtain the write hold with the given TTL, which is a plain Lock once obtained.
+// May return ErrNotObtained if the lock is held by the readers or another writer.
+func (rw *RWLock) Lock(ttl time.Duration, opt *Options) (*Lock, error) {
+	token, err := rw.client.randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	wake, stop := rw.client.notifications(opt, rw.key)
+	defer stop()
+
+	lock := &Lock{client: rw.client, key: rw.key, value: token + opt.getMetadata()}
+	keys := []string{rw.key, ReadersKey(rw.key), siblingKey(rw.key, "intent"), siblingKey(rw.key, "fence")}
+	err = attempt(ttl, opt, wake, func() (ok bool, err error) {
+		lock.fence, err = luaWriteObtain.Run(rw.client.client, keys, lock.value, milliseconds(ttl), milliseconds(sinceEpoch()), boolArg(opt.getFence())).Int64()
+		return lock.fence > 0, err
+	})
+	if err != nil {
+		_ = luaIntentRelease.Run(rw.client.client, keys[2:3], lock.value, ReleaseChannel(rw.key)).Err()
+		return nil, err
+	}
+	return lock.fenced(opt), nil
+}
+
+// Key returns the redis key used by the lock.
+func (l *ReadLock) Key() string {
+	return l.key
+}
+
+// Token returns the token of the read hold.
+func (l *ReadLock) Token() string {
+	return l.token
+}
+
+// Refresh extends the read hold with a new TTL.
+// May return ErrNotObtained if refresh is unsuccessful.
+func (l *ReadLock) Refresh(ttl time.Duration, opt *Options) error {
+	ok, err := luaHoldRefresh.Run(l.client.client, []string{ReadersKey(l.key)}, l.token, milliseconds(ttl), milliseconds(sinceEpoch())).Int64()
+	if err != nil {
+		return err
+	} else if ok == 1 {
+		return nil
+	}
+	return ErrNotObtained
+}
+
+// Release releases the read hold.
+// May return ErrLockNotHeld.
+func (l *ReadLock) Release() error {
+	n, err := luaReadRelease.Run(l.client.client, []string{ReadersKey(l.key)}, l.token, ReleaseChannel(l.key)).Int64()
+	if err != nil {
+		return err
+	} else if n == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+func milliseconds(d time.Duration) string {
+	return strconv.FormatInt(int64(d/time.Millisecond), 10)
+}
+
+// boolArg formats the flag as a script argument, "1" or "0".
+func boolArg(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func sinceEpoch() time.Duration {
+	return time.Duration(time.Now().UnixNano())
+}
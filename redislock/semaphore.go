@@ -0,0 +1,118 @@
+package redislock
+
+import (
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// The holders of a semaphore are a sorted set of {token: expiration (ms)} at the key,
+// the expired ones are dropped before counting.
+var (
+	// KEYS: key
+	// ARGV: token, ttl (ms), now (ms), limit
+	luaSemaphoreAcquire = redis.NewScript(`
+redis.call("zremrangebyscore", KEYS[1], "-inf", ARGV[3])
+if redis.call("zcard", KEYS[1]) >= tonumber(ARGV[4]) then
+	return 0
+end
+redis.call("zadd", KEYS[1], tonumber(ARGV[3]) + tonumber(ARGV[2]), ARGV[1])
+if redis.call("pttl", KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 1`)
+	luaSemaphoreCount = redis.NewScript(`redis.call("zremrangebyscore", KEYS[1], "-inf", ARGV[1]) return redis.call("zcard", KEYS[1])`)
+)
+
+// Semaphore is a distributed counting semaphore: up to limit holders can hold it at the same time,
+// each for the TTL unless refreshed.
+// The expirations of the holds are based on the clocks of the clients.
+type Semaphore struct {
+	client *Client
+	key    string
+	limit  int
+	ttl    time.Duration
+}
+
+// Permit represents an acquired hold of a Semaphore.
+type Permit struct {
+	sem   *Semaphore
+	token string
+}
+
+// Semaphore returns the semaphore of the key, allowing up to limit holders.
+func (c *Client) Semaphore(key string, limit int, ttl time.Duration) *Semaphore {
+	return &Semaphore{client: c, key: key, limit: limit, ttl: ttl}
+}
+
+// Key returns the redis key used by the semaphore.
+func (s *Semaphore) Key() string {
+	return s.key
+}
+
+// Limit returns the max count of the holders.
+func (s *Semaphore) Limit() int {
+	return s.limit
+}
+
+// Holders returns the count of the current holders.
+func (s *Semaphore) Holders() (int64, error) {
+	return luaSemaphoreCount.Run(s.client.client, []string{s.key}, milliseconds(sinceEpoch())).Int64()
+}
+
+// Acquire tries to acquire a hold. Unlike Obtain, the waiting is not bound by the TTL, but by
+// the retry strategy and the context of the options only.
+// May return ErrNotObtained if not successful.
+func (s *Semaphore) Acquire(opt *Options) (*Permit, error) {
+	token, err := s.client.randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	wake, stop := s.client.notifications(opt, s.key)
+	defer stop()
+
+	err = attempt(0, opt, wake, func() (bool, error) {
+		ok, err := luaSemaphoreAcquire.Run(s.client.client, []string{s.key}, token,
+			milliseconds(s.ttl), milliseconds(sinceEpoch()), s.limit).Int64()
+		return ok == 1, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Permit{sem: s, token: token}, nil
+}
+
+// Key returns the redis key used by the semaphore.
+func (p *Permit) Key() string {
+	return p.sem.key
+}
+
+// Token returns the token of the hold.
+func (p *Permit) Token() string {
+	return p.token
+}
+
+// Refresh extends the hold with a new TTL.
+// May return ErrNotObtained if refresh is unsuccessful (e.g. the hold expired).
+func (p *Permit) Refresh(ttl time.Duration) error {
+	ok, err := luaHoldRefresh.Run(p.sem.client.client, []string{p.sem.key}, p.token, milliseconds(ttl), milliseconds(sinceEpoch())).Int64()
+	if err != nil {
+		return err
+	} else if ok == 1 {
+		return nil
+	}
+	return ErrNotObtained
+}
+
+// Release releases the hold.
+// May return ErrLockNotHeld.
+func (p *Permit) Release() error {
+	n, err := luaReadRelease.Run(p.sem.client.client, []string{p.sem.key}, p.token, ReleaseChannel(p.sem.key)).Int64()
+	if err != nil {
+		return err
+	} else if n == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
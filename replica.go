@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+type ReplicaSelection int
+
+const (
+	RandomReplica     ReplicaSelection = iota
+	RoundRobinReplica                  // in turn
+	LatencyReplica                     // the lowest (moving average) latency of the recent reads
+)
+
+// Replicas serves `Get` and the read half of `Fetch` when initialized by `InitReplicas`,
+// unless `Opt.ReadPrimary` is given. Writes, locks and counters always go to the primary.
+var Replicas *ReplicaSet
+
+// ReplicaSet selects a replica client for each read.
+type ReplicaSet struct {
+	selection ReplicaSelection
+	clients   []redis.UniversalClient
+
+	mu      sync.Mutex
+	next    int
+	latency []time.Duration
+}
+
+// InitReplicas configures the read replicas of the primary (`Cli`), it does not apply to `Shards`.
+// Calling it without clients disables the replica reads.
+func InitReplicas(selection ReplicaSelection, clients ...redis.UniversalClient) {
+	if len(clients) == 0 {
+		Replicas = nil
+		return
+	}
+
+	for _, cli := range clients {
+		cli.AddHook(Hook{})
+	}
+	Replicas = &ReplicaSet{selection: selection, clients: clients, latency: make([]time.Duration, len(clients))}
+}
+
+// readFrom calls fn with the client the read should be served by, and falls back to
+// the primary when the replica fails (except key not found).
+func readFrom(key string, opt Opt, fn func(cli redis.UniversalClient) error) error {
+	if opt.ReadPrimary || Replicas == nil || Shards != nil {
+		return fn(cliFor(key))
+	}
+
+	i, cli := Replicas.pick()
+	start := time.Now()
+	err := fn(cli)
+	Replicas.observe(i, time.Since(start))
+	if err != nil && !IsKeyNotFound(err) {
+		return fn(Cli)
+	}
+	return err
+}
+
+func (r *ReplicaSet) pick() (int, redis.UniversalClient) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := 0
+	switch r.selection {
+	case RoundRobinReplica:
+		i = r.next
+		r.next = (r.next + 1) % len(r.clients)
+	case LatencyReplica:
+		if rand.Intn(16) == 0 { // explores, so a replica recovered from a slow period can be picked again
+			i = rand.Intn(len(r.clients))
+			break
+		}
+		for j, latency := range r.latency {
+			if latency < r.latency[i] {
+				i = j
+			}
+		}
+	default:
+		i = rand.Intn(len(r.clients))
+	}
+	return i, r.clients[i]
+}
+
+func (r *ReplicaSet) observe(i int, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.latency[i] == 0 {
+		r.latency[i] = d
+	} else {
+		r.latency[i] = (r.latency[i]*4 + d) / 5
+	}
+}
@@ -0,0 +1,155 @@
+package cache
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/pkg/errors"
+)
+
+// DefaultVirtualNodes is the count of the points each shard (of weight 1) places on the hash ring.
+var DefaultVirtualNodes = 160
+
+// Shards routes the operations over independent Redis nodes when initialized by `InitSharded`.
+var Shards *ShardedStore
+
+// ErrNoShards is returned when removing the last shard of a store, which would leave no client for the keys.
+var ErrNoShards = errors.New("cache: no shard left in the store")
+
+// Shard is an independent Redis node of a ShardedStore.
+type Shard struct {
+	Name   string // Identifies the shard on the ring, keep it stable across deploys
+	Client redis.UniversalClient
+	Weight int // Default 1, a shard of weight 2 gets about twice the keys
+}
+
+// ShardedStore distributes the keys over its shards by consistent hashing (with virtual nodes),
+// so adding or removing a shard only remaps the keys of that shard.
+// The hash tag of a key is honored, so `{user:42}:a` and `{user:42}:b` are on the same shard.
+type ShardedStore struct {
+	vnodes int
+
+	mu     sync.RWMutex
+	shards map[string]Shard
+	ring   []uint32
+	owners map[uint32]string
+}
+
+func NewShardedStore(shards ...Shard) *ShardedStore {
+	s := &ShardedStore{vnodes: DefaultVirtualNodes, shards: map[string]Shard{}}
+	for _, shard := range shards {
+		s.shards[shard.Name] = shard
+	}
+	s.rebuild()
+	return s
+}
+
+// InitSharded is like `Init`, but the operations are routed to the shards of the store,
+// which must have a shard (it panics otherwise).
+func InitSharded(store *ShardedStore, logger interface{ Println(args ...interface{}) }) {
+	if len(store.Clients()) == 0 {
+		panic(ErrNoShards)
+	}
+	Shards = store
+	for _, cli := range store.Clients() {
+		cli.AddHook(Hook{})
+	}
+	if logger != nil {
+		Logger = logger
+	}
+}
+
+// AddShard adds (or replaces) the shard with the same name.
+func (s *ShardedStore) AddShard(shard Shard) {
+	s.mu.Lock()
+	s.shards[shard.Name] = shard
+	s.rebuild()
+	s.mu.Unlock()
+
+	if Shards == s {
+		shard.Client.AddHook(Hook{})
+	}
+}
+
+// RemoveShard removes the shard from the ring, its client is not closed.
+// May return ErrNoShards if it is the last shard, which is kept.
+func (s *ShardedStore) RemoveShard(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.shards[name]; ok && len(s.shards) == 1 {
+		return ErrNoShards
+	}
+	delete(s.shards, name)
+	s.rebuild()
+	return nil
+}
+
+// ShardFor returns the name of the shard owning the key, "" if there is no shard.
+func (s *ShardedStore) ShardFor(key string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.owner(key)
+}
+
+// ClientFor returns the client of the shard owning the key, nil if the store has no shard yet.
+func (s *ShardedStore) ClientFor(key string) redis.UniversalClient {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.shards[s.owner(key)].Client
+}
+
+func (s *ShardedStore) Clients() []redis.UniversalClient {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make([]string, 0, len(s.shards))
+	for name := range s.shards {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	clients := make([]redis.UniversalClient, 0, len(names))
+	for _, name := range names {
+		clients = append(clients, s.shards[name].Client)
+	}
+	return clients
+}
+
+func (s *ShardedStore) owner(key string) string {
+	if len(s.ring) == 0 {
+		return ""
+	}
+
+	h := crc32.ChecksumIEEE([]byte(hashTagOf(key)))
+	i := sort.Search(len(s.ring), func(i int) bool { return s.ring[i] >= h })
+	if i == len(s.ring) {
+		i = 0
+	}
+	return s.owners[s.ring[i]]
+}
+
+func (s *ShardedStore) rebuild() {
+	s.ring = s.ring[:0]
+	s.owners = map[uint32]string{}
+	for name, shard := range s.shards {
+		weight := shard.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		for i := 0; i < s.vnodes*weight; i++ {
+			h := crc32.ChecksumIEEE([]byte(name + "#" + strconv.Itoa(i)))
+			if owner, ok := s.owners[h]; ok && owner < name {
+				continue // keeps the ring independent of the map iteration order
+			}
+			if _, ok := s.owners[h]; !ok {
+				s.ring = append(s.ring, h)
+			}
+			s.owners[h] = name
+		}
+	}
+	sort.Slice(s.ring, func(i, j int) bool { return s.ring[i] < s.ring[j] })
+}
@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis/v7"
+)
+
+const slotCount = 16384
+
+// Slot returns the Redis Cluster hash slot of the key, honoring its hash tag.
+func Slot(key string) int {
+	return int(crc16(hashTagOf(key)) % slotCount)
+}
+
+// HashTag wraps the tag in braces, keys containing the same hash tag are co-located
+// on the same cluster slot: HashTag("user:42") => "{user:42}"
+func HashTag(tag string) string {
+	return "{" + tag + "}"
+}
+
+// HashTagKey builds a key co-located with the other keys of the tag:
+// HashTagKey("user:42", "profile") => "{user:42}:profile"
+func HashTagKey(tag string, parts ...string) string {
+	return strings.Join(append([]string{HashTag(tag)}, parts...), ":")
+}
+
+func isCluster() bool {
+	_, ok := Cli.(*redis.ClusterClient)
+	return ok
+}
+
+// isDistributed reports whether the keys may live on different nodes (or slots),
+// so the multi-key commands and scripts have to be split.
+func isDistributed() bool {
+	return Shards != nil || isCluster()
+}
+
+// cliFor returns the client serving the key: its shard when sharded, Cli otherwise.
+func cliFor(key string) redis.UniversalClient {
+	if Shards != nil {
+		return Shards.ClientFor(key)
+	}
+	return Cli
+}
+
+// forEachBatch calls fn with the keys grouped by shard when sharded, by hash slot when Cli is
+// a cluster client, or with all the keys at once otherwise.
+func forEachBatch(keys []string, fn func(cli redis.UniversalClient, keys []string) error) error {
+	if !isDistributed() {
+		return fn(Cli, keys)
+	}
+
+	groupOf := func(key string) string { return strconv.Itoa(Slot(key)) }
+	if Shards != nil {
+		groupOf = Shards.ShardFor
+	}
+
+	order := []string{}
+	groups := map[string][]string{}
+	for _, key := range keys {
+		group := groupOf(key)
+		if _, ok := groups[group]; !ok {
+			order = append(order, group)
+		}
+		groups[group] = append(groups[group], key)
+	}
+	for _, group := range order {
+		if err := fn(cliFor(groups[group][0]), groups[group]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nodes returns every shard when sharded, every master of a cluster, or Cli itself.
+func nodes() ([]redis.UniversalClient, error) {
+	if Shards != nil {
+		return Shards.Clients(), nil
+	}
+
+	cluster, ok := Cli.(*redis.ClusterClient)
+	if !ok {
+		return []redis.UniversalClient{Cli}, nil
+	}
+
+	var mu sync.Mutex
+	result := []redis.UniversalClient{}
+	err := cluster.ForEachMaster(func(client *redis.Client) error {
+		mu.Lock()
+		result = append(result, client)
+		mu.Unlock()
+		return nil
+	})
+	return result, err
+}
+
+func hashTagOf(key string) string {
+	if s := strings.IndexByte(key, '{'); s > -1 {
+		if e := strings.IndexByte(key[s+1:], '}'); e > 0 {
+			return key[s+1 : s+e+1]
+		}
+	}
+	return key
+}
+
+// crc16 implements CRC16-CCITT (XMODEM), which is used by Redis Cluster.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,288 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/pkg/errors"
+)
+
+// Keys are recorded in per-tag sets (`__tag:<tag>`), and each tagged key keeps
+// an index of its own tags (see tagIndexKey) so that re-setting or invalidating
+// it can drop the memberships it no longer has.
+// The tag sets expire with their longest-lived member, the index with its key.
+const tagPrefix = "__tag:"
+
+// tagIndexKey returns the key of the tag index, which is on the same cluster slot (and shard)
+// as the key, so the index is read and dropped atomically with the write of the key.
+func tagIndexKey(key string) string {
+	if tag := hashTagOf(key); tag != key {
+		return key + ":__tags"
+	}
+	return HashTag(key) + ":__tags"
+}
+
+// KEYS: key, key's tag index, tag sets...
+// ARGV: value, expiration in ms (0 means no expiration)
+var luaSetTagged = redis.NewScript(`
+for _, tag in ipairs(redis.call("smembers", KEYS[2])) do
+	redis.call("srem", tag, KEYS[1])
+end
+redis.call("del", KEYS[2])
+
+local px = tonumber(ARGV[2])
+if px > 0 then
+	redis.call("set", KEYS[1], ARGV[1], "px", px)
+else
+	redis.call("set", KEYS[1], ARGV[1])
+end
+
+for i = 3, #KEYS do
+	local ttl = redis.call("pttl", KEYS[i])
+	redis.call("sadd", KEYS[i], KEYS[1])
+	redis.call("sadd", KEYS[2], KEYS[i])
+	if px <= 0 then
+		redis.call("persist", KEYS[i])
+	elseif ttl == -2 or (ttl >= 0 and ttl < px) then
+		redis.call("pexpire", KEYS[i], px)
+	end
+end
+if px > 0 then
+	redis.call("pexpire", KEYS[2], px)
+end
+return 1`)
+
+// KEYS: tag sets..., their keys..., the tag indexes of the keys..., the tags of the keys...
+// ARGV: count of the tag sets, count of the keys
+// Returns -1 if the keys or their tags have changed since they were read.
+var luaInvalidateTags = redis.NewScript(`
+local t, k = tonumber(ARGV[1]), tonumber(ARGV[2])
+local keys, tags = {}, {}
+for i = 1, #KEYS do
+	if i > t and i <= t + k then
+		keys[KEYS[i]] = true
+	elseif i <= t or i > t + 2 * k then
+		tags[KEYS[i]] = true
+	end
+end
+for i = 1, t do
+	for _, key in ipairs(redis.call("smembers", KEYS[i])) do
+		if not keys[key] then
+			return -1
+		end
+	end
+end
+for i = t + k + 1, t + 2 * k do
+	for _, tag in ipairs(redis.call("smembers", KEYS[i])) do
+		if not tags[tag] then
+			return -1
+		end
+	end
+end
+
+local n = 0
+for i = t + 1, t + k do
+	for _, tag in ipairs(redis.call("smembers", KEYS[i + k])) do
+		redis.call("srem", tag, KEYS[i])
+	end
+	redis.call("del", KEYS[i + k])
+	n = n + redis.call("del", KEYS[i])
+end
+for i = 1, t do
+	redis.call("del", KEYS[i])
+end
+return n`)
+
+// invalidateTagsAttempts is how many times InvalidateTags re-reads the tagged keys if they
+// change before they are deleted.
+var invalidateTagsAttempts = 5
+
+// InvalidateTags deletes every key recorded under the given tags (see `Opt.Tags`)
+// in one atomic step, and removes the tag sets themselves.
+// On a cluster (or shards) the keys span slots, so it is done step by step instead.
+func InvalidateTags(tags ...string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	var err error
+	if isDistributed() {
+		err = invalidateTagsAcrossSlots(tagKeys(tags))
+	} else {
+		err = invalidateTags(tagKeys(tags))
+	}
+	return errors.Wrap(err, "cache.InvalidateTags")
+}
+
+// invalidateTags reads the keys under the tags and their other tags, so luaInvalidateTags
+// declares every key it touches, and retries if they change meanwhile.
+func invalidateTags(tags []string) error {
+	for i := 0; i < invalidateTagsAttempts; i++ {
+		keys, err := Cli.SUnion(tags...).Result()
+		if err != nil {
+			return err
+		}
+		indexes := make([]string, len(keys))
+		for j, key := range keys {
+			indexes[j] = tagIndexKey(key)
+		}
+		others := []string{}
+		if len(indexes) > 0 {
+			if others, err = Cli.SUnion(indexes...).Result(); err != nil {
+				return err
+			}
+		}
+
+		declared := append(append(append(append([]string{}, tags...), keys...), indexes...), others...)
+		n, err := luaInvalidateTags.Run(Cli, declared, len(tags), len(keys)).Int()
+		if err != nil || n >= 0 {
+			return err
+		}
+	}
+	return errors.New("the tagged keys kept changing")
+}
+
+// TaggedKeys returns the keys currently recorded under the tag.
+func TaggedKeys(tag string) ([]string, error) {
+	keys, err := cliFor(tagPrefix + tag).SMembers(tagPrefix + tag).Result()
+	return keys, errors.Wrap(err, "cache.TaggedKeys")
+}
+
+func setTagged(ctx context.Context, key string, value string, opt Opt) error {
+	if isDistributed() {
+		return setTaggedAcrossSlots(ctx, key, value, opt)
+	}
+
+	keys := append([]string{key, tagIndexKey(key)}, tagKeys(opt.Tags)...)
+	px := int64(opt.ExpiresIn / time.Millisecond)
+	return luaSetTagged.Run(withContext(ctx, Cli), keys, value, px).Err()
+}
+
+// setUntagged sets the key without tags, dropping the tags of its previous Set if any.
+// The tag index is read and deleted in the same transaction as the write, and the tag sets
+// are only touched for the keys that were tagged.
+func setUntagged(ctx context.Context, key string, value string, opt Opt) error {
+	if opt.Fence > 0 {
+		return fencedWrite(ctx, key, value, opt)
+	}
+
+	index := tagIndexKey(key)
+	var tags *redis.StringSliceCmd
+	_, err := withContext(ctx, cliFor(key)).TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Set(key, value, opt.ExpiresIn)
+		tags = pipe.SMembers(index)
+		pipe.Del(index)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return removeTagged(ctx, key, tags.Val())
+}
+
+// setTaggedAcrossSlots does what luaSetTagged does, but command by command.
+func setTaggedAcrossSlots(ctx context.Context, key string, value string, opt Opt) error {
+	index := tagIndexKey(key)
+	if err := untag(ctx, key, ""); err != nil {
+		return err
+	}
+	if err := withContext(ctx, cliFor(key)).Set(key, value, opt.ExpiresIn).Err(); err != nil {
+		return err
+	}
+
+	tags := tagKeys(opt.Tags)
+	for _, tag := range tags {
+		if err := addTagged(ctx, tag, key, opt.ExpiresIn); err != nil {
+			return err
+		}
+	}
+	members := make([]interface{}, 0, len(tags))
+	for _, tag := range tags {
+		members = append(members, tag)
+	}
+	if err := withContext(ctx, cliFor(index)).SAdd(index, members...).Err(); err != nil {
+		return err
+	}
+	if opt.ExpiresIn > 0 {
+		return withContext(ctx, cliFor(index)).PExpire(index, opt.ExpiresIn).Err()
+	}
+	return nil
+}
+
+// addTagged records the key in the tag set, which is kept at least as long as the key.
+func addTagged(ctx context.Context, tag, key string, expiration time.Duration) error {
+	cli := withContext(ctx, cliFor(tag))
+	ttl, err := cli.PTTL(tag).Result()
+	if err != nil {
+		return err
+	}
+	if err = cli.SAdd(tag, key).Err(); err != nil {
+		return err
+	}
+
+	if expiration <= 0 {
+		return cli.Persist(tag).Err()
+	} else if ttl == -2 || ttl >= 0 && ttl < expiration { // -2: a new set
+		return cli.PExpire(tag, expiration).Err()
+	}
+	return nil
+}
+
+// invalidateTagsAcrossSlots does what luaInvalidateTags does, but command by command.
+func invalidateTagsAcrossSlots(tags []string) error {
+	for _, tag := range tags {
+		keys, err := cliFor(tag).SMembers(tag).Result()
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err = untag(nil, key, tag); err != nil {
+				return err
+			}
+		}
+		if err = Delete(append(keys, tag)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// untag removes the key from the tag sets it was recorded in (except the `keep` one),
+// and deletes its tag index.
+func untag(ctx context.Context, key string, keep string) error {
+	index := tagIndexKey(key)
+	cli := withContext(ctx, cliFor(index))
+	tags, err := cli.SMembers(index).Result()
+	if err != nil || len(tags) == 0 {
+		return err
+	}
+	for i, tag := range tags {
+		if tag == keep {
+			tags = append(tags[:i], tags[i+1:]...)
+			break
+		}
+	}
+	if err = removeTagged(ctx, key, tags); err != nil {
+		return err
+	}
+	return cli.Del(index).Err()
+}
+
+// removeTagged removes the key from the tag sets.
+func removeTagged(ctx context.Context, key string, tags []string) error {
+	for _, tag := range tags {
+		if err := withContext(ctx, cliFor(tag)).SRem(tag, key).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func tagKeys(tags []string) []string {
+	keys := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		keys = append(keys, tagPrefix+tag)
+	}
+	return keys
+}
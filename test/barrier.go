@@ -0,0 +1,64 @@
+package cache_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/go-web-kits/cache"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Barrier & CountDownLatch", func() {
+	BeforeEach(func() {
+		Expect(Delete("__latch:stage", "__barrier:stage")).To(Succeed())
+	})
+
+	Describe("CountDownLatch", func() {
+		It("releases the waiters when counted down to 0", func() {
+			latch := CountDownLatch("stage", 2)
+			Expect(latch.Count()).To(Equal(int64(2)))
+
+			done := make(chan error)
+			go func() { done <- latch.Wait(context.Background()) }()
+			Expect(latch.CountDown()).To(Equal(int64(1)))
+			Consistently(done, 100*time.Millisecond).ShouldNot(Receive())
+
+			Expect(CountDownLatch("stage", 2).CountDown()).To(Equal(int64(0)))
+			Eventually(done).Should(Receive(BeNil()))
+			Expect(latch.CountDown()).To(Equal(int64(0)))
+			Expect(Cli.PTTL("__latch:stage").Val()).To(BeNumerically(">", 0))
+		})
+
+		It("stops waiting when the ctx is done", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			err := CountDownLatch("stage", 1).Wait(ctx)
+			Expect(err).To(HaveOccurred())
+			Expect(ctx.Err()).To(HaveOccurred())
+		})
+	})
+
+	Describe("Barrier", func() {
+		It("blocks until every party arrives, round by round", func() {
+			for round := 0; round < 2; round++ {
+				done := make(chan error, 3)
+				for i := 0; i < 2; i++ {
+					go func() { done <- Barrier("stage", 3).Await(context.Background()) }()
+				}
+				Consistently(done, 100*time.Millisecond).ShouldNot(Receive())
+
+				Expect(Barrier("stage", 3).Await(context.Background())).To(Succeed())
+				Eventually(done).Should(Receive(BeNil()))
+				Eventually(done).Should(Receive(BeNil()))
+			}
+		})
+
+		It("withdraws the arrival when the ctx is done", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			Expect(Barrier("stage", 2).Await(ctx)).NotTo(Succeed())
+			Expect(Cli.HGet("__barrier:stage", "arrived").Val()).To(Equal("0"))
+		})
+	})
+})
@@ -23,15 +23,31 @@ var _ = Describe("Cache", func() {
 	Describe("DeleteMatched", func() {
 		It("does successfully", func() {
 			Expect(Set("key1", "test")).To(Succeed())
-			Expect(DeleteMatched("key*")).To(Succeed())
-			Expect(DeleteMatched("fetch*")).To(Succeed())
-			_, err := Get("key1")
+			n, err := DeleteMatched("key*")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n).To(BeNumerically(">=", 1))
+			_, err = DeleteMatched("fetch*")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = Get("key1")
 			Expect(err).To(HaveOccurred())
 		})
 
+		It("deletes page by page and reports the progress", func() {
+			for _, k := range []string{"dm1", "dm2", "dm3", "dm4", "dm5"} {
+				Expect(Set(k, k)).To(Succeed())
+			}
+
+			var progress []int64
+			Expect(DeleteMatched("dm*", Opt{ScanCount: 2, Progress: func(deleted int64) {
+				progress = append(progress, deleted)
+			}})).To(Equal(int64(5)))
+			Expect(progress).NotTo(BeEmpty())
+			Expect(progress[len(progress)-1]).To(Equal(int64(5)))
+		})
+
 		When("no key matched", func() {
 			It("does nothing", func() {
-				Expect(DeleteMatched("xqwe*")).To(Succeed())
+				Expect(DeleteMatched("xqwe*")).To(BeZero())
 			})
 		})
 	})
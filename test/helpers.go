@@ -0,0 +1,24 @@
+package cache_test
+
+import (
+	"github.com/go-redis/redis/v7"
+	. "github.com/go-web-kits/cache"
+	. "github.com/onsi/gomega"
+)
+
+// clientOn returns a client of another DB on the server of Cli, which stands for
+// another instance (a Redlock instance, a shard or a replica) in the specs.
+func clientOn(db int) *redis.Client {
+	opt := *Cli.(*redis.Client).Options()
+	opt.DB = db
+	return redis.NewClient(&opt)
+}
+
+// flushDBs removes the keys left in the DBs by the specs.
+func flushDBs(dbs ...int) {
+	for _, db := range dbs {
+		cli := clientOn(db)
+		Expect(cli.FlushDB().Err()).To(Succeed())
+		Expect(cli.Close()).To(Succeed())
+	}
+}
@@ -0,0 +1,171 @@
+package cache_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	. "github.com/go-web-kits/cache"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Hook", func() {
+	var (
+		buf     *bytes.Buffer
+		records = func(cmd string) []map[string]interface{} {
+			result := []map[string]interface{}{}
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				record := map[string]interface{}{}
+				if json.Unmarshal([]byte(line), &record) == nil && record["cmd"] == cmd {
+					result = append(result, record)
+				}
+			}
+			return result
+		}
+	)
+
+	BeforeEach(func() {
+		buf = &bytes.Buffer{}
+		UseSlog(slog.New(slog.NewJSONHandler(buf, nil)))
+	})
+
+	AfterEach(func() {
+		UseSlog(slog.New(ColorHandler{}))
+	})
+
+	It("logs the structured records of the commands", func() {
+		Expect(Set("hook", "value")).To(Succeed())
+		Expect(Get("hook")).To(Equal("value"))
+		Expect(Delete("hook")).To(Succeed())
+
+		sets := records("set")
+		Expect(sets).To(HaveLen(1))
+		Expect(sets[0]["msg"]).To(Equal("SET"))
+		Expect(sets[0]["keys"]).To(Equal([]interface{}{"hook"}))
+		Expect(sets[0]).To(HaveKey("duration"))
+		Expect(sets[0]).NotTo(HaveKey("error"))
+
+		gets := records("get")
+		Expect(gets).To(HaveLen(1))
+		Expect(gets[0]["size"]).To(BeNumerically(">", 0))
+	})
+
+	It("logs the errors at the error level", func() {
+		Expect(Set("hook", "value")).To(Succeed())
+		Expect(Cli.HGet("hook", "field").Err()).To(HaveOccurred())
+
+		hgets := records("hget")
+		Expect(hgets).To(HaveLen(1))
+		Expect(hgets[0]["level"]).To(Equal("ERROR"))
+		Expect(hgets[0]["error"]).To(ContainSubstring("WRONGTYPE"))
+	})
+
+	It("logs the commands of the pipelines, with a summary", func() {
+		pipe := Cli.Pipeline()
+		pipe.Set("hook", "value", 0)
+		pipe.HGet("hook", "field")
+		_, err := pipe.Exec()
+		Expect(err).To(HaveOccurred())
+
+		sets := records("set")
+		Expect(sets).To(HaveLen(1))
+		Expect(sets[0]["pipeline"]).To(BeTrue())
+		Expect(records("hget")[0]["level"]).To(Equal("ERROR"))
+		Expect(records("hget")[0]["duration"]).To(Equal(sets[0]["duration"]))
+
+		summary := records("pipeline")
+		Expect(summary).To(HaveLen(1))
+		Expect(summary[0]["msg"]).To(Equal("PIPELINE"))
+		Expect(summary[0]["args"]).To(Equal("set hget"))
+		Expect(summary[0]["commands"]).To(BeNumerically("==", 2))
+		Expect(summary[0]["errors"]).To(BeNumerically("==", 1))
+		Expect(summary[0]["level"]).To(Equal("ERROR"))
+	})
+
+	Describe("slow threshold, redaction & sampling", func() {
+		AfterEach(func() {
+			SlowThreshold, LogOnlySlow, MaxLogArgLen, RedactPatterns, LogSampling = 0, false, 0, nil, nil
+		})
+
+		It("logs only the slow commands, at the warn level", func() {
+			SlowThreshold, LogOnlySlow = time.Hour, true
+			Expect(Set("hook", "value")).To(Succeed())
+			Expect(records("set")).To(BeEmpty())
+
+			SlowThreshold = time.Nanosecond
+			Expect(Set("hook", "value")).To(Succeed())
+			Expect(records("set")).To(HaveLen(1))
+			Expect(records("set")[0]["level"]).To(Equal("WARN"))
+			Expect(records("set")[0]["slow"]).To(BeTrue())
+		})
+
+		It("redacts the values of the matched keys, and truncates the others", func() {
+			RedactPatterns, MaxLogArgLen = []string{"session:*"}, 8
+			Expect(Set("session:1", "secret")).To(Succeed())
+			Expect(Set("hook", strings.Repeat("v", 100))).To(Succeed())
+			Expect(Delete("session:1")).To(Succeed())
+
+			sets := records("set")
+			Expect(sets).To(HaveLen(2))
+			Expect(sets[0]["args"]).To(Equal("session:1 [REDACTED]"))
+			Expect(sets[1]["args"]).To(HavePrefix("hook:: "))
+			Expect(sets[1]["args"]).To(ContainSubstring("...("))
+			Expect(len(sets[1]["args"].(string))).To(BeNumerically("<", 50))
+		})
+
+		It("redacts the commands touching a matched key at any position", func() {
+			RedactPatterns = []string{"session:*"}
+			Expect(Cli.MSet("hook", "value", "session:1", "secret").Err()).To(Succeed())
+			pipe := Cli.Pipeline()
+			pipe.MSet("hook", "value", "session:1", "secret")
+			_, err := pipe.Exec()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(Delete("session:1")).To(Succeed())
+
+			msets := records("mset")
+			Expect(msets).To(HaveLen(2))
+			for _, mset := range msets {
+				Expect(mset["keys"]).To(Equal([]interface{}{"hook", "session:1"}))
+				Expect(mset["args"]).To(Equal("hook session:1 [REDACTED]"))
+			}
+		})
+
+		It("outputs the level, slow=true & the error in the colored lines", func() {
+			lines, previous := &lineLogger{}, Logger
+			Logger = lines
+			UseSlog(slog.New(ColorHandler{}))
+			defer func() { Logger = previous }()
+
+			SlowThreshold = time.Nanosecond
+			Expect(Cli.Set("hook", "value", 0).Err()).To(Succeed())
+			Expect(Cli.HGet("hook", "field").Err()).To(HaveOccurred())
+
+			Expect(*lines).To(HaveLen(2))
+			Expect((*lines)[0]).To(ContainSubstring("WARN SET"))
+			Expect((*lines)[0]).To(ContainSubstring("slow=true"))
+			Expect((*lines)[1]).To(ContainSubstring("ERROR HGET"))
+			Expect((*lines)[1]).To(ContainSubstring(`error="WRONGTYPE`))
+		})
+
+		It("samples the commands, except the errors", func() {
+			LogSampling = map[string]float64{"get": 0, "hget": 0}
+			Expect(Set("hook", "value")).To(Succeed())
+			_, _ = Get("hook")
+			Expect(Cli.HGet("hook", "field").Err()).To(HaveOccurred())
+
+			Expect(records("get")).To(BeEmpty())
+			Expect(records("set")).To(HaveLen(1))
+			Expect(records("hget")).To(HaveLen(1))
+		})
+	})
+})
+
+type lineLogger []string
+
+func (l *lineLogger) Println(args ...interface{}) {
+	*l = append(*l, fmt.Sprint(args...))
+}
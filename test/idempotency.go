@@ -0,0 +1,84 @@
+package cache_test
+
+import (
+	"sync/atomic"
+	"time"
+
+	. "github.com/go-web-kits/cache"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+var _ = Describe("Idempotent", func() {
+	BeforeEach(func() {
+		Expect(Delete("__idem:req-1")).To(Succeed())
+	})
+
+	It("runs the handler once, and returns the stored result to the duplicates", func() {
+		var calls int32
+		handler := func() (interface{}, error) {
+			atomic.AddInt32(&calls, 1)
+			time.Sleep(100 * time.Millisecond)
+			return map[string]int{"id": 1}, nil
+		}
+
+		done := make(chan interface{}, 2)
+		results := make(chan interface{}, 2)
+		for i := 0; i < 2; i++ {
+			go func() {
+				defer GinkgoRecover()
+				var to map[string]int
+				result, err := Idempotent("req-1", handler, Opt{To: &to})
+				Expect(err).NotTo(HaveOccurred())
+				done <- to
+				results <- result
+			}()
+		}
+		Eventually(done).Should(Receive(Equal(map[string]int{"id": 1})))
+		Eventually(done).Should(Receive(Equal(map[string]int{"id": 1})))
+		var result1, result2 interface{}
+		Eventually(results).Should(Receive(&result1))
+		Eventually(results).Should(Receive(&result2))
+		Expect(result1).To(Equal(result2))
+		Expect(calls).To(Equal(int32(1)))
+
+		Expect(Idempotent("req-1", handler)).To(Equal(map[string]interface{}{"id": 1.0}))
+		Expect(calls).To(Equal(int32(1)))
+		Expect(Cli.PTTL("__idem:req-1").Val()).To(BeNumerically(">", time.Hour))
+	})
+
+	It("does not store the errors", func() {
+		_, err := Idempotent("req-1", func() (interface{}, error) { return nil, errors.New("failed") })
+		Expect(err).To(MatchError("failed"))
+
+		Expect(Idempotent("req-1", func() (interface{}, error) { return 1, nil })).To(Equal(1))
+		Expect(Idempotent("req-1", func() (interface{}, error) { return 2, nil })).To(Equal(1))
+	})
+
+	It("keeps the claim while the handler runs", func() {
+		defer func(ttl time.Duration) { IdempotencyClaimTTL = ttl }(IdempotencyClaimTTL)
+		IdempotencyClaimTTL = 300 * time.Millisecond
+
+		Expect(Idempotent("req-1", func() (interface{}, error) {
+			Expect(Cli.PExpire("__idem:req-1", 10*time.Second).Err()).To(Succeed())
+			time.Sleep(200 * time.Millisecond)
+			Expect(Cli.PTTL("__idem:req-1").Val()).To(BeNumerically("<=", 300*time.Millisecond))
+			return 1, nil
+		})).To(Equal(1))
+	})
+
+	It("reports a lost claim", func() {
+		_, err := Idempotent("req-1", func() (interface{}, error) {
+			Expect(Delete("__idem:req-1")).To(Succeed())
+			return 1, nil
+		})
+		Expect(errors.Cause(err)).To(Equal(ErrClaimLost))
+	})
+
+	It("gives up waiting for the in-flight handler after WaitTimeout", func() {
+		Expect(Cli.Set("__idem:req-1", "__pending:someone", time.Minute).Err()).To(Succeed())
+		_, err := Idempotent("req-1", func() (interface{}, error) { return 1, nil }, Opt{WaitTimeout: 50 * time.Millisecond})
+		Expect(errors.Cause(err)).To(Equal(ErrWaitTimeout))
+	})
+})
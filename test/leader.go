@@ -0,0 +1,56 @@
+package cache_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/go-web-kits/cache"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LeaderElector", func() {
+	BeforeEach(func() {
+		Expect(Delete("__lock:cron")).To(Succeed())
+	})
+
+	It("elects one leader, and hands over on shutdown", func() {
+		a, b := NewLeaderElector("cron", 300*time.Millisecond), NewLeaderElector("cron", 300*time.Millisecond)
+		elected := make(chan context.Context, 1)
+		a.OnElected = func(ctx context.Context) { elected <- ctx }
+		changes := b.Watch()
+
+		ctxA, stopA := context.WithCancel(context.Background())
+		doneA := make(chan bool)
+		go func() { a.Campaign(ctxA); close(doneA) }()
+		var leading context.Context
+		Eventually(elected).Should(Receive(&leading))
+		Expect(a.IsLeader()).To(BeTrue())
+
+		ctxB, stopB := context.WithCancel(context.Background())
+		defer stopB()
+		go b.Campaign(ctxB)
+		Consistently(b.IsLeader, 300*time.Millisecond).Should(BeFalse())
+
+		stopA()
+		Eventually(doneA).Should(BeClosed())
+		Expect(a.IsLeader()).To(BeFalse())
+		Expect(leading.Err()).To(Equal(context.Canceled))
+		Eventually(changes).Should(Receive(BeTrue()))
+		Expect(b.IsLeader()).To(BeTrue())
+	})
+
+	It("steps down when the lock is lost", func() {
+		e := NewLeaderElector("cron", 300*time.Millisecond)
+		steppedDown := make(chan bool, 1)
+		e.OnStepDown = func() { steppedDown <- true }
+		ctx, stop := context.WithCancel(context.Background())
+		defer stop()
+		go e.Campaign(ctx)
+		Eventually(e.IsLeader).Should(BeTrue())
+
+		Expect(Cli.Set("__lock:cron", "someone else", 0).Err()).To(Succeed())
+		Eventually(steppedDown).Should(Receive())
+		Expect(e.IsLeader()).To(BeFalse())
+	})
+})
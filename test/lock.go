@@ -0,0 +1,673 @@
+package cache_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	. "github.com/go-web-kits/cache"
+	"github.com/go-web-kits/cache/redislock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+var _ = Describe("Lock", func() {
+	Describe("Redlock", func() {
+		var instances []redis.UniversalClient
+
+		BeforeEach(func() {
+			instances = []redis.UniversalClient{clientOn(8), clientOn(9), clientOn(10)}
+			for _, instance := range instances {
+				Expect(instance.Del("__lock:rl").Err()).To(Succeed())
+			}
+			UseRedlock(instances...)
+		})
+
+		AfterEach(func() {
+			LockBackend = nil
+			flushDBs(8, 9, 10)
+		})
+
+		It("obtains the lock on every instance", func() {
+			lock, err := GetLock("rl", time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			for _, instance := range instances {
+				Expect(instance.Get("__lock:rl").Val()).To(HavePrefix(lock.Token()))
+			}
+
+			_, err = GetLock("rl", time.Second)
+			Expect(err).To(HaveOccurred())
+
+			Expect(lock.TTL()).To(BeNumerically(">", 0))
+			Expect(lock.Refresh(2*time.Second, nil)).To(Succeed())
+			Expect(lock.Release()).To(Succeed())
+			for _, instance := range instances {
+				Expect(instance.Exists("__lock:rl").Val()).To(BeZero())
+			}
+		})
+
+		It("obtains the lock on a majority", func() {
+			Expect(instances[0].Set("__lock:rl", "other", time.Second).Err()).To(Succeed())
+			lock, err := GetLock("rl", time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lock.Release()).To(Succeed())
+			Expect(instances[0].Get("__lock:rl").Val()).To(Equal("other"))
+		})
+
+		It("fails without a majority, and leaves nothing behind", func() {
+			Expect(instances[0].Set("__lock:rl", "other", time.Second).Err()).To(Succeed())
+			Expect(instances[1].Set("__lock:rl", "other", time.Second).Err()).To(Succeed())
+			_, err := GetLock("rl", time.Second)
+			Expect(err).To(MatchError(ContainSubstring(redislock.ErrNotObtained.Error())))
+			Expect(instances[2].Exists("__lock:rl").Val()).To(BeZero())
+		})
+
+		It("runs the lambda under the lock", func() {
+			ran := false
+			Expect(Lock("rl", time.Second, func() error {
+				ran = true
+				return nil
+			})).To(Succeed())
+			Expect(ran).To(BeTrue())
+		})
+
+		It("counts the fencing tokens on the first instance", func() {
+			for _, instance := range instances {
+				Expect(instance.Del("{__lock:rl}:fence").Err()).To(Succeed())
+			}
+			Expect(instances[1].Set("{__lock:rl}:fence", 100, 0).Err()).To(Succeed())
+
+			lock1, err := GetLock("rl", time.Second, LockOpt{Fence: true})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lock1.Release()).To(Succeed())
+			Expect(instances[0].Set("__lock:rl", "other", time.Second).Err()).To(Succeed())
+			lock2, err := GetLock("rl", time.Second, LockOpt{Fence: true})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lock2.Release()).To(Succeed())
+
+			Expect([]int64{lock1.Fence(), lock2.Fence()}).To(Equal([]int64{1, 2}))
+			Expect(instances[2].Exists("{__lock:rl}:fence").Val()).To(BeZero())
+		})
+	})
+
+	Describe("Lock", func() {
+		BeforeEach(func() {
+			Expect(Delete("__lock:lk")).To(Succeed())
+		})
+
+		It("releases the lock when the lambda fails", func() {
+			err := Lock("lk", 10*time.Second, func() error { return errors.New("failed") })
+			Expect(err).To(MatchError("failed"))
+			Expect(Cli.Exists("__lock:lk").Val()).To(BeZero())
+		})
+
+		It("releases the lock and re-raises when the lambda panics", func() {
+			Expect(func() {
+				_ = Lock("lk", 10*time.Second, func() error { panic("boom") })
+			}).To(PanicWith("boom"))
+			Expect(Cli.Exists("__lock:lk").Val()).To(BeZero())
+		})
+
+		It("reports the failed release along with the error of the lambda", func() {
+			failed := errors.New("failed")
+			err := Lock("lk", 10*time.Second, func() error {
+				Expect(Delete("__lock:lk")).To(Succeed())
+				return failed
+			})
+			Expect(errors.Cause(err)).To(Equal(failed))
+			Expect(err.Error()).To(ContainSubstring(redislock.ErrLockNotHeld.Error()))
+		})
+
+		It("keeps the lock on failure with KeepOnError", func() {
+			err := Lock("lk", 10*time.Second, func() error { return errors.New("failed") }, LockOpt{KeepOnError: true})
+			Expect(err).To(HaveOccurred())
+			Expect(Cli.Exists("__lock:lk").Val()).To(Equal(int64(1)))
+
+			Expect(Lock("lk", 10*time.Second, func() error { return nil }, LockOpt{KeepOnError: true})).NotTo(Succeed())
+		})
+	})
+
+	Describe("KeepAlive", func() {
+		BeforeEach(func() {
+			Expect(Delete("__lock:ka")).To(Succeed())
+		})
+
+		It("refreshes every third of the ttl by default", func() {
+			lock, err := GetLock("ka", 300*time.Millisecond)
+			Expect(err).NotTo(HaveOccurred())
+			ctx, stop := lock.KeepAlive(context.Background(), 300*time.Millisecond, 0)
+			defer stop()
+
+			Expect(Cli.PExpire("__lock:ka", 10*time.Second).Err()).To(Succeed())
+			time.Sleep(200 * time.Millisecond)
+			Expect(Cli.PTTL("__lock:ka").Val()).To(BeNumerically("<=", 300*time.Millisecond))
+			Expect(ctx.Err()).NotTo(HaveOccurred())
+			Expect(lock.Release()).To(Succeed())
+		})
+
+		It("gives up before the lock expires if the refreshes fail", func() {
+			client := clientOn(Cli.(*redis.Client).Options().DB)
+			lock, err := redislock.New(client).Obtain("__lock:ka", 300*time.Millisecond, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(client.Close()).To(Succeed())
+
+			start := time.Now()
+			ctx, stop := lock.KeepAlive(context.Background(), 300*time.Millisecond, 100*time.Millisecond)
+			defer stop()
+			Eventually(ctx.Done()).Should(BeClosed())
+			Expect(time.Since(start)).To(BeNumerically("<", 280*time.Millisecond))
+		})
+	})
+
+	Describe("LockAutoRenew", func() {
+		BeforeEach(func() {
+			Expect(Delete("__lock:ar")).To(Succeed())
+		})
+
+		It("keeps the lock while the lambda runs over the ttl, and releases it", func() {
+			Expect(LockAutoRenew("ar", 300*time.Millisecond, func(ctx context.Context) error {
+				time.Sleep(500 * time.Millisecond)
+				Expect(ctx.Err()).NotTo(HaveOccurred())
+				Expect(Cli.Exists("__lock:ar").Val()).To(Equal(int64(1)))
+				return nil
+			})).To(Succeed())
+			Expect(Cli.Exists("__lock:ar").Val()).To(BeZero())
+		})
+
+		It("cancels the ctx when the lock is lost", func() {
+			err := LockAutoRenew("ar", 300*time.Millisecond, func(ctx context.Context) error {
+				Expect(Delete("__lock:ar")).To(Succeed())
+				select {
+				case <-ctx.Done():
+				case <-time.After(time.Second):
+					Fail("the ctx is not cancelled")
+				}
+				return nil
+			})
+			Expect(errors.Cause(err)).To(Equal(redislock.ErrLockLost))
+		})
+
+		It("returns the error of the lambda", func() {
+			Expect(LockAutoRenew("ar", time.Second, func(ctx context.Context) error {
+				return errors.New("failed")
+			})).To(MatchError("failed"))
+			Expect(Cli.Exists("__lock:ar").Val()).To(BeZero())
+		})
+
+		It("stops renewing the lock kept with KeepOnError", func() {
+			Expect(LockAutoRenew("ar", 300*time.Millisecond, func(ctx context.Context) error {
+				return errors.New("failed")
+			}, LockOpt{KeepOnError: true})).To(MatchError("failed"))
+			Expect(Cli.PExpire("__lock:ar", 10*time.Second).Err()).To(Succeed())
+
+			time.Sleep(300 * time.Millisecond) // a renewal would shorten it back to the ttl
+			Expect(Cli.PTTL("__lock:ar").Val()).To(BeNumerically(">", time.Second))
+		})
+	})
+
+	Describe("Fence", func() {
+		BeforeEach(func() {
+			Expect(Delete("__lock:fc", "{__lock:fc}:fence", "fc", "{fc}:__fence")).To(Succeed())
+		})
+
+		It("increases on each obtain", func() {
+			lock1, err := GetLock("fc", time.Second, LockOpt{Fence: true})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lock1.Release()).To(Succeed())
+
+			lock2, err := GetLock("fc", time.Second, LockOpt{Fence: true})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lock2.Fence()).To(BeNumerically(">", lock1.Fence()))
+			Expect(lock2.Release()).To(Succeed())
+		})
+
+		It("keeps the counter of a hash-tagged key apart from the locks", func() {
+			Expect(Delete("__lock:{u2}:x", "__lock:{u2}:x:fence", "__lock:{u2}:x:readers")).To(Succeed())
+			lock, err := GetLock("{u2}:x", time.Second, LockOpt{Fence: true})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lock.Release()).To(Succeed())
+			read, err := GetRWLock("{u2}:x").RLock(time.Second, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, key := range []string{"{u2}:x:fence", "{u2}:x:readers"} {
+				other, err := GetLock(key, time.Second)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(other.Release()).To(Succeed())
+			}
+			Expect(read.Release()).To(Succeed())
+			Expect(Delete("{u2}:__lockmeta:__lock:{u2}:x:fence")).To(Succeed())
+		})
+
+		It("is not counted unless asked for", func() {
+			lock, err := GetLock("fc", time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lock.Fence()).To(BeZero())
+			Expect(lock.Release()).To(Succeed())
+			Expect(Cli.Exists("{__lock:fc}:fence").Val()).To(BeZero())
+		})
+
+		It("rejects the writes carrying a stale token", func() {
+			lock1, _ := GetLock("fc", time.Second, LockOpt{Fence: true})
+			Expect(lock1.Release()).To(Succeed())
+			lock2, _ := GetLock("fc", time.Second, LockOpt{Fence: true})
+
+			Expect(Set("fc", "new", Opt{Fence: lock2.Fence()})).To(Succeed())
+			err := Set("fc", "stale", Opt{Fence: lock1.Fence()})
+			Expect(errors.Cause(err)).To(Equal(ErrStaleFence))
+			Expect(Get("fc")).To(Equal("new"))
+
+			Expect(errors.Cause(DeleteFenced("fc", lock1.Fence()))).To(Equal(ErrStaleFence))
+			Expect(DeleteFenced("fc", lock2.Fence())).To(Succeed())
+			_, err = Get("fc")
+			Expect(IsKeyNotFound(err)).To(BeTrue())
+			Expect(lock2.Release()).To(Succeed())
+		})
+	})
+
+	Describe("LockReentrant", func() {
+		BeforeEach(func() {
+			Expect(Delete("__lock:re")).To(Succeed())
+		})
+
+		It("can be locked again by the same owner", func() {
+			depth := 0
+			Expect(LockReentrant("re", "job-1", time.Second, func() error {
+				return LockReentrant("re", "job-1", time.Second, func() error {
+					depth++
+					_, err := GetLock("re", time.Second)
+					Expect(err).To(HaveOccurred())
+					return nil
+				})
+			})).To(Succeed())
+			Expect(depth).To(Equal(1))
+			Expect(Cli.Exists("__lock:re").Val()).To(BeZero())
+		})
+
+		It("excludes the other owners until every hold is released", func() {
+			lock1, err := GetReentrantLock("re", "job-1", time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			lock2, err := GetReentrantLock("re", "job-1", time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lock2.Holds()).To(Equal(int64(2)))
+			Expect(lock2.TTL()).To(BeNumerically(">", 0))
+
+			_, err = GetReentrantLock("re", "job-2", time.Second)
+			Expect(err).To(HaveOccurred())
+
+			Expect(lock2.Release()).To(Succeed())
+			_, err = GetReentrantLock("re", "job-2", time.Second)
+			Expect(err).To(HaveOccurred())
+
+			Expect(lock1.Release()).To(Succeed())
+			Expect(lock1.Release()).To(Equal(redislock.ErrLockNotHeld))
+			lock3, err := GetReentrantLock("re", "job-2", time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lock3.Release()).To(Succeed())
+		})
+
+		It("does not shorten the ttl when locked again", func() {
+			Expect(LockReentrant("re", "job-1", 10*time.Second, func() error {
+				return LockReentrant("re", "job-1", 100*time.Millisecond, func() error {
+					Expect(Cli.PTTL("__lock:re").Val()).To(BeNumerically(">", 5*time.Second))
+					return nil
+				})
+			})).To(Succeed())
+		})
+
+		It("is not obtained while the key is held by a plain lock", func() {
+			lock, err := GetLock("re", time.Second)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = GetReentrantLock("re", "job-1", time.Second)
+			Expect(errors.Cause(err)).To(Equal(redislock.ErrNotObtained))
+			Expect(lock.Release()).To(Succeed())
+		})
+	})
+
+	Describe("RWLock", func() {
+		var rw *redislock.RWLock
+
+		BeforeEach(func() {
+			Expect(Delete("__lock:rw", redislock.ReadersKey("__lock:rw"), "{__lock:rw}:intent")).To(Succeed())
+			rw = GetRWLock("rw")
+		})
+
+		It("is shared by the readers, and excludes the writers", func() {
+			r1, err := rw.RLock(time.Second, nil)
+			Expect(err).NotTo(HaveOccurred())
+			r2, err := rw.RLock(time.Second, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = rw.Lock(time.Second, nil)
+			Expect(err).To(HaveOccurred())
+
+			Expect(r1.Release()).To(Succeed())
+			Expect(r2.Release()).To(Succeed())
+			w, err := rw.Lock(time.Second, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = rw.RLock(time.Second, nil)
+			Expect(err).To(HaveOccurred())
+			Expect(w.Release()).To(Succeed())
+		})
+
+		It("stops the new readers while a writer is waiting", func() {
+			r1, _ := rw.RLock(time.Second, nil)
+			_, err := rw.Lock(time.Second, &redislock.Options{
+				RetryStrategy: redislock.LimitRetry(redislock.LinearBackoff(10*time.Millisecond), 5),
+				Context:       context.Background(),
+			})
+			Expect(err).To(HaveOccurred())
+			r2, err := rw.RLock(time.Second, nil) // the writer gave up
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r2.Release()).To(Succeed())
+
+			go func() {
+				defer GinkgoRecover()
+				time.Sleep(100 * time.Millisecond)
+				_, err := rw.RLock(time.Second, nil)
+				Expect(err).To(HaveOccurred())
+				Expect(r1.Release()).To(Succeed())
+			}()
+
+			w, err := rw.Lock(time.Second, &redislock.Options{RetryStrategy: redislock.LinearBackoff(10 * time.Millisecond)})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(w.Release()).To(Succeed())
+		})
+
+		It("makes the reads wait only on the writers", func() {
+			Expect(LockRead("rw", time.Second, func() error {
+				Expect(Set("rw", 1)).To(Succeed())
+				Expect(Get("rw", Opt{UnderLocking: true, FailIfLocked: true})).To(Equal(1))
+				Expect(Set("rw", 2, Opt{UnderLocking: true, FailIfLocked: true})).NotTo(Succeed())
+				return nil
+			})).To(Succeed())
+
+			Expect(LockWrite("rw", time.Second, func() error {
+				_, err := Get("rw", Opt{UnderLocking: true, FailIfLocked: true})
+				Expect(err).To(HaveOccurred())
+				return nil
+			})).To(Succeed())
+		})
+
+		It("does not make the writes wait on the expired read holds", func() {
+			_, err := rw.RLock(100*time.Millisecond, nil) // a crashed reader
+			Expect(err).NotTo(HaveOccurred())
+			read, err := rw.RLock(3*time.Second, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(read.Release()).To(Succeed())
+
+			time.Sleep(150 * time.Millisecond)
+			Expect(Set("rw", 1, Opt{UnderLocking: true, FailIfLocked: true})).To(Succeed())
+		})
+	})
+
+	Describe("waiting for the release", func() {
+		var interval time.Duration
+
+		BeforeEach(func() {
+			Expect(Delete("__lock:nt")).To(Succeed())
+			interval, SpinInterval = SpinInterval, 10*time.Second // so only the notification wakes up
+		})
+
+		AfterEach(func() {
+			SpinInterval = interval
+		})
+
+		It("wakes up UnderLocking on release", func() {
+			lock, err := GetLock("nt", 10*time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			time.AfterFunc(50*time.Millisecond, func() { _ = lock.Release() })
+
+			start := time.Now()
+			Expect(Set("nt", 1, Opt{UnderLocking: true})).To(Succeed())
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		})
+
+		It("gives up after WaitTimeout", func() {
+			lock, _ := GetLock("nt", 10*time.Second)
+			err := Set("nt", 1, Opt{UnderLocking: true, WaitTimeout: 50 * time.Millisecond})
+			Expect(errors.Cause(err)).To(Equal(ErrWaitTimeout))
+			Expect(lock.Release()).To(Succeed())
+		})
+
+		It("gives up when the RetryStrategy does", func() {
+			lock, _ := GetLock("nt", 10*time.Second)
+			opt := Opt{UnderLocking: true, RetryStrategy: func() redislock.RetryStrategy {
+				return redislock.LimitRetry(redislock.LinearBackoff(10*time.Millisecond), 2)
+			}}
+			err := Set("nt", 1, opt)
+			Expect(errors.Cause(err)).To(Equal(ErrWaitTimeout))
+
+			start := time.Now()
+			opt.RetryStrategy = func() redislock.RetryStrategy {
+				return redislock.DeadlineRetry(redislock.DecorrelatedJitter(10*time.Millisecond, time.Second), 100*time.Millisecond)
+			}
+			Expect(errors.Cause(Set("nt", 1, opt))).To(Equal(ErrWaitTimeout))
+			Expect(time.Since(start)).To(BeNumerically("~", 100*time.Millisecond, 50*time.Millisecond))
+
+			start = time.Now() // a new strategy for each call, not the expired deadline of the previous one
+			Expect(errors.Cause(Set("nt", 1, opt))).To(Equal(ErrWaitTimeout))
+			Expect(time.Since(start)).To(BeNumerically("~", 100*time.Millisecond, 50*time.Millisecond))
+			Expect(lock.Release()).To(Succeed())
+		})
+
+		It("wakes up the retries of Obtain with Notify", func() {
+			lock, _ := GetLock("nt", 10*time.Second)
+			time.AfterFunc(50*time.Millisecond, func() { _ = lock.Release() })
+
+			start := time.Now()
+			lock, err := redislock.New(Cli).Obtain("__lock:nt", 10*time.Second, &redislock.Options{
+				RetryStrategy: redislock.LinearBackoff(10 * time.Second),
+				Notify:        true,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+			Expect(lock.Release()).To(Succeed())
+		})
+	})
+
+	Describe("LockFair", func() {
+		BeforeEach(func() {
+			Expect(Delete("__lock:ff", "{__lock:ff}:queue", "{__lock:ff}:queue:timeouts")).To(Succeed())
+		})
+
+		It("grants the lock in the arrival order", func() {
+			lock, err := GetLock("ff", 10*time.Second)
+			Expect(err).NotTo(HaveOccurred())
+
+			var mu sync.Mutex
+			order := []int{}
+			done := make(chan bool)
+			for i := 1; i <= 3; i++ {
+				go func(i int) {
+					defer GinkgoRecover()
+					Expect(LockFair("ff", 5*time.Second, func() error {
+						mu.Lock()
+						order = append(order, i)
+						mu.Unlock()
+						return nil
+					})).To(Succeed())
+					done <- true
+				}(i)
+				time.Sleep(50 * time.Millisecond)
+			}
+
+			Expect(lock.Release()).To(Succeed())
+			for i := 0; i < 3; i++ {
+				Eventually(done, 3*time.Second).Should(Receive())
+			}
+			Expect(order).To(Equal([]int{1, 2, 3}))
+		})
+
+		It("skips the expired contenders", func() {
+			Expect(Cli.ZAdd("{__lock:ff}:queue", &redis.Z{Score: 0, Member: "crashed"}).Err()).To(Succeed())
+			Expect(Cli.ZAdd("{__lock:ff}:queue:timeouts", &redis.Z{Score: 1, Member: "crashed"}).Err()).To(Succeed())
+
+			ran := false
+			Expect(LockFair("ff", time.Second, func() error {
+				ran = true
+				return nil
+			})).To(Succeed())
+			Expect(ran).To(BeTrue())
+		})
+	})
+
+	Describe("Semaphore", func() {
+		BeforeEach(func() {
+			Expect(Delete("__sem:exports")).To(Succeed())
+		})
+
+		It("admits up to the limit of holders", func() {
+			sem := Semaphore("exports", 2, 10*time.Second)
+			p1, err := sem.Acquire(nil)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = sem.Acquire(nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = sem.Acquire(nil)
+			Expect(err).To(Equal(redislock.ErrNotObtained))
+			Expect(sem.Holders()).To(Equal(int64(2)))
+
+			Expect(p1.Release()).To(Succeed())
+			Expect(p1.Release()).To(Equal(redislock.ErrLockNotHeld))
+			_, err = sem.Acquire(nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("drops the expired holders, unless refreshed", func() {
+			sem := Semaphore("exports", 1, time.Second)
+			Expect(Cli.ZAdd("__sem:exports", &redis.Z{Score: 1, Member: "crashed"}).Err()).To(Succeed())
+
+			p, err := sem.Acquire(nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(p.Refresh(10 * time.Second)).To(Succeed())
+			Expect(sem.Holders()).To(Equal(int64(1)))
+
+			Expect(p.Release()).To(Succeed())
+			Expect(p.Refresh(time.Second)).To(Equal(redislock.ErrNotObtained))
+		})
+
+		It("waits for a release, until the context is done", func() {
+			sem := Semaphore("exports", 1, 10*time.Second)
+			p, err := sem.Acquire(nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			_, err = sem.Acquire(&redislock.Options{Context: ctx, RetryStrategy: redislock.LinearBackoff(10 * time.Millisecond)})
+			Expect(err).To(Equal(context.DeadlineExceeded))
+
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				_ = p.Release()
+			}()
+			_, err = sem.Acquire(&redislock.Options{RetryStrategy: redislock.LinearBackoff(time.Hour), Notify: true})
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Describe("ListLocks & ForceUnlock", func() {
+		BeforeEach(func() {
+			Expect(Delete("__lock:job:1", "__lock:job:2")).To(Succeed())
+		})
+
+		It("lists the held locks with their owners", func() {
+			lock, err := GetLock("job:1", 10*time.Second, LockOpt{Purpose: "export"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lock.Metadata()).To(ContainSubstring(`"purpose":"export"`))
+			_, err = GetReentrantLock("job:2", "worker", 10*time.Second)
+			Expect(err).NotTo(HaveOccurred())
+
+			locks, err := ListLocks("job:*")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(locks).To(HaveLen(2))
+			for _, info := range locks {
+				Expect(info.TTL).To(BeNumerically(">", 0))
+				if info.Key == "job:1" {
+					Expect(info.Owner.Purpose).To(Equal("export"))
+					Expect(info.Owner.PID).NotTo(BeZero())
+				} else {
+					Expect(info.Key).To(Equal("job:2"))
+					Expect(info.Owner).To(BeNil())
+				}
+			}
+		})
+
+		It("leaves out the keys kept beside the locks", func() {
+			lock, err := GetLock("{u1}:y", 10*time.Second, LockOpt{Fence: true})
+			Expect(err).NotTo(HaveOccurred())
+			read, err := GetRWLock("{u1}:z").RLock(10*time.Second, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			locks, err := ListLocks("{u1}:*")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(locks).To(HaveLen(1))
+			Expect(locks[0].Key).To(Equal("{u1}:y"))
+
+			Expect(lock.Release()).To(Succeed())
+			Expect(read.Release()).To(Succeed())
+			Expect(Delete("{u1}:__lockmeta:__lock:{u1}:y:fence")).To(Succeed())
+		})
+
+		It("breaks the lock of any holder", func() {
+			lock, err := GetLock("job:1", 10*time.Second)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ForceUnlock("job:1", "the worker is dead")).To(Succeed())
+			Expect(lock.Release()).To(Equal(redislock.ErrLockNotHeld))
+			Expect(errors.Cause(ForceUnlock("job:1", "again"))).To(Equal(redislock.ErrLockNotHeld))
+		})
+
+		It("logs the audit entry at WARN, regardless of UnLog", func() {
+			buf := &bytes.Buffer{}
+			UseSlog(slog.New(slog.NewJSONHandler(buf, nil)))
+			UnLog = true
+			defer func() {
+				UseSlog(slog.New(ColorHandler{}))
+				UnLog = false
+			}()
+
+			_, err := GetLock("job:1", 10*time.Second, LockOpt{Purpose: "export"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ForceUnlock("job:1", "the worker is dead")).To(Succeed())
+
+			record := map[string]interface{}{}
+			Expect(json.Unmarshal(buf.Bytes(), &record)).To(Succeed())
+			Expect(record["level"]).To(Equal("WARN"))
+			Expect(record["msg"]).To(Equal("FORCE UNLOCK"))
+			Expect(record["reason"]).To(Equal("the worker is dead"))
+			Expect(record["owner"]).To(HaveKeyWithValue("purpose", "export"))
+		})
+	})
+
+	Describe("RetryStrategy", func() {
+		It("jitters the exponential backoffs", func() {
+			strategy := redislock.JitteredExponentialBackoff(16*time.Millisecond, 64*time.Millisecond)
+			for i := 0; i < 10; i++ {
+				Expect(strategy.NextBackoff()).To(And(
+					BeNumerically(">=", 8*time.Millisecond), BeNumerically("<=", 64*time.Millisecond)))
+			}
+		})
+
+		It("decorrelates the backoffs within the base and the max", func() {
+			strategy := redislock.DecorrelatedJitter(10*time.Millisecond, 50*time.Millisecond)
+			for i := 0; i < 10; i++ {
+				Expect(strategy.NextBackoff()).To(And(
+					BeNumerically(">=", 10*time.Millisecond), BeNumerically("<=", 50*time.Millisecond)))
+			}
+		})
+
+		It("stops at the deadline", func() {
+			strategy := redislock.DeadlineRetry(redislock.LinearBackoff(time.Hour), 50*time.Millisecond)
+			Expect(strategy.NextBackoff()).To(BeNumerically("<=", 50*time.Millisecond))
+			time.Sleep(60 * time.Millisecond)
+			Expect(strategy.NextBackoff()).To(BeZero())
+		})
+	})
+})
@@ -0,0 +1,81 @@
+package cache_test
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	. "github.com/go-web-kits/cache"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metrics", func() {
+	var metrics *PrometheusMetrics
+
+	BeforeEach(func() {
+		Expect(Delete("metrics:1", "metrics:2")).To(Succeed())
+		metrics = NewPrometheusMetrics()
+		Metrics = metrics
+	})
+
+	AfterEach(func() {
+		Metrics = nil
+	})
+
+	It("counts the hits, the misses and the loads", func() {
+		Expect(Set("metrics:1", 1)).To(Succeed())
+		Expect(Get("metrics:1")).To(Equal(1))
+		_, _ = Get("metrics:2")
+		Expect(Fetch("metrics:2", Opt{Default: func() interface{} { return 2 }})).To(Equal(2))
+		_, err := Fetch("metrics:3", Opt{Default: func() interface{} { return errors.New("db down") }})
+		Expect(err).To(HaveOccurred())
+
+		snapshot := metrics.Snapshot()
+		Expect(snapshot.Counters[MetricHits]).To(Equal(int64(1)))
+		Expect(snapshot.Counters[MetricMisses]).To(Equal(int64(3)))
+		Expect(snapshot.Counters[MetricSets]).To(Equal(int64(2)))
+		Expect(snapshot.Counters[MetricLoads]).To(Equal(int64(2)))
+		Expect(snapshot.Counters[MetricLoadErrors]).To(Equal(int64(1)))
+		Expect(snapshot.Histograms[MetricLoadDuration].Count).To(Equal(int64(2)))
+		Expect(snapshot.Histograms[MetricCommandDuration+`{cmd="get"}`].Count).To(Equal(int64(4)))
+	})
+
+	It("counts the lock waits", func() {
+		lock, err := GetLock("metrics:1", 10*time.Second)
+		Expect(err).NotTo(HaveOccurred())
+		time.AfterFunc(50*time.Millisecond, func() { _ = lock.Release() })
+		Expect(Set("metrics:1", 1, Opt{UnderLocking: true})).To(Succeed())
+
+		snapshot := metrics.Snapshot()
+		Expect(snapshot.Counters[MetricLockWaits]).To(Equal(int64(1)))
+		Expect(snapshot.Histograms[MetricLockWaitSeconds].Sum).To(BeNumerically(">=", 40*time.Millisecond))
+	})
+
+	It("exposes the metrics in the Prometheus text format", func() {
+		Expect(Set("metrics:1", 1)).To(Succeed())
+		_, _ = Get("metrics:2")
+
+		var buf bytes.Buffer
+		_, err := metrics.WriteTo(&buf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(buf.String()).To(ContainSubstring("# TYPE cache_misses_total counter\ncache_misses_total 1\n"))
+		Expect(buf.String()).To(ContainSubstring("# TYPE cache_command_duration_seconds histogram\n"))
+		Expect(buf.String()).To(ContainSubstring(`cache_command_duration_seconds_bucket{cmd="set",le="+Inf"} 1`))
+		Expect(buf.String()).To(ContainSubstring(`cache_command_duration_seconds_count{cmd="set"} 1`))
+	})
+
+	It("groups the series by their metrics, and escapes the label values", func() {
+		prometheus := NewPrometheusMetrics()
+		prometheus.Inc("cache_x", nil)
+		prometheus.Inc("cache_x_y", nil)
+		prometheus.Inc("cache_x", Labels{"cmd": "a\"b\\c\nd\te"})
+
+		var buf bytes.Buffer
+		_, err := prometheus.WriteTo(&buf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(buf.String()).To(Equal("# TYPE cache_x counter\ncache_x 1\n" +
+			`cache_x{cmd="a\"b\\c\nd` + "\te\"} 1\n" +
+			"# TYPE cache_x_y counter\ncache_x_y 1\n"))
+	})
+})
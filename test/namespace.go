@@ -0,0 +1,56 @@
+package cache_test
+
+import (
+	. "github.com/go-web-kits/cache"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Namespace", func() {
+	var products = Namespace("products")
+
+	It("is shared by name", func() {
+		Expect(Namespace("products")).To(BeIdenticalTo(products))
+	})
+
+	It("embeds the generation into the keys", func() {
+		gen, err := products.Generation()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(products.Set("1", "apple")).To(Succeed())
+		Expect(products.Get("1")).To(Equal("apple"))
+
+		key, _ := products.Key("1")
+		Expect(key).To(HaveSuffix(":1"))
+		Expect(Get(key)).To(Equal("apple"))
+		Expect(Cli.PTTL(key).Val()).To(BeNumerically(">", 0))
+		Expect(products.Generation()).To(Equal(gen))
+	})
+
+	Describe("Flush", func() {
+		It("bumps the generation, so the old keys are unreachable", func() {
+			Expect(products.Set("2", "banana")).To(Succeed())
+			gen, _ := products.Generation()
+
+			Expect(products.Flush()).To(Succeed())
+			Expect(products.Generation()).To(Equal(gen + 1))
+			_, err := products.Get("2")
+			Expect(IsKeyNotFound(err)).To(BeTrue())
+		})
+
+		It("does not affect other namespaces", func() {
+			orders := Namespace("orders")
+			Expect(orders.Set("1", "order")).To(Succeed())
+			Expect(products.Flush()).To(Succeed())
+			Expect(orders.Get("1")).To(Equal("order"))
+		})
+	})
+
+	Describe("Fetch", func() {
+		It("sets the default under the current generation", func() {
+			Expect(products.Flush()).To(Succeed())
+			Expect(products.Fetch("3", Opt{Default: "cherry"})).To(Equal("cherry"))
+			Expect(products.Get("3")).To(Equal("cherry"))
+			Expect(products.Fetch("4")).To(BeNil())
+		})
+	})
+})
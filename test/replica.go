@@ -0,0 +1,55 @@
+package cache_test
+
+import (
+	"github.com/go-redis/redis/v7"
+	. "github.com/go-web-kits/cache"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Replicas", func() {
+	var r1, r2 redis.UniversalClient
+
+	BeforeEach(func() {
+		r1, r2 = clientOn(6), clientOn(7)
+		// the replicas are simulated by other DBs, so their (un-encoded) values are distinguishable
+		Expect(r1.Set("rep", "r1", 0).Err()).To(Succeed())
+		Expect(r2.Set("rep", "r2", 0).Err()).To(Succeed())
+		Expect(Set("rep", "primary")).To(Succeed())
+	})
+
+	AfterEach(func() {
+		InitReplicas(RandomReplica)
+		flushDBs(6, 7)
+	})
+
+	It("serves Get from the replicas", func() {
+		InitReplicas(RandomReplica, r1)
+		Expect(Get("rep")).To(Equal("r1"))
+		Expect(Fetch("rep")).To(Equal("r1"))
+	})
+
+	It("selects the replicas in turn", func() {
+		InitReplicas(RoundRobinReplica, r1, r2)
+		Expect(Get("rep")).To(Equal("r1"))
+		Expect(Get("rep")).To(Equal("r2"))
+		Expect(Get("rep")).To(Equal("r1"))
+	})
+
+	It("selects a replica by latency", func() {
+		InitReplicas(LatencyReplica, r1, r2)
+		Expect(Get("rep")).To(BeElementOf("r1", "r2"))
+	})
+
+	It("reads from the primary when ReadPrimary is given", func() {
+		InitReplicas(RandomReplica, r1, r2)
+		Expect(Get("rep", Opt{ReadPrimary: true})).To(Equal("primary"))
+	})
+
+	It("writes to the primary", func() {
+		InitReplicas(RandomReplica, r1)
+		Expect(Set("rep", "new")).To(Succeed())
+		Expect(Get("rep", Opt{ReadPrimary: true})).To(Equal("new"))
+		Expect(Get("rep")).To(Equal("r1"))
+	})
+})
@@ -0,0 +1,137 @@
+package cache_test
+
+import (
+	"fmt"
+
+	. "github.com/go-web-kits/cache"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ShardedStore", func() {
+	var (
+		shardOn = func(db int) Shard {
+			return Shard{Name: fmt.Sprint("db", db), Client: clientOn(db)}
+		}
+		keys = func(n int) []string {
+			result := []string{}
+			for i := 0; i < n; i++ {
+				result = append(result, fmt.Sprint("user:", i))
+			}
+			return result
+		}
+	)
+
+	AfterEach(func() {
+		flushDBs(2, 3, 4, 5)
+	})
+
+	Describe("the ring", func() {
+		It("distributes the keys over the shards", func() {
+			store := NewShardedStore(shardOn(2), shardOn(3), shardOn(4))
+			count := map[string]int{}
+			for _, key := range keys(3000) {
+				count[store.ShardFor(key)]++
+			}
+			Expect(count).To(HaveLen(3))
+			for _, n := range count {
+				Expect(n).To(BeNumerically("~", 1000, 300))
+			}
+		})
+
+		It("honors the weights", func() {
+			heavy := shardOn(3)
+			heavy.Weight = 3
+			store := NewShardedStore(shardOn(2), heavy)
+			count := map[string]int{}
+			for _, key := range keys(4000) {
+				count[store.ShardFor(key)]++
+			}
+			Expect(count["db3"]).To(BeNumerically(">", 2*count["db2"]))
+		})
+
+		It("remaps only the keys of the added or removed shard", func() {
+			store := NewShardedStore(shardOn(2), shardOn(3), shardOn(4))
+			before := map[string]string{}
+			for _, key := range keys(3000) {
+				before[key] = store.ShardFor(key)
+			}
+
+			store.AddShard(shardOn(5))
+			moved := 0
+			for key, shard := range before {
+				if now := store.ShardFor(key); now != shard {
+					Expect(now).To(Equal("db5"))
+					moved++
+				}
+			}
+			Expect(moved).To(BeNumerically("~", 750, 250))
+
+			Expect(store.RemoveShard("db5")).To(Succeed())
+			for key, shard := range before {
+				Expect(store.ShardFor(key)).To(Equal(shard))
+			}
+		})
+
+		It("keeps the last shard", func() {
+			single := NewShardedStore(shardOn(5))
+			Expect(single.RemoveShard("db5")).To(Equal(ErrNoShards))
+			Expect(single.ClientFor("key")).NotTo(BeNil())
+			Expect(single.RemoveShard("unknown")).To(Succeed())
+		})
+
+		It("co-locates the keys with the same hash tag", func() {
+			store := NewShardedStore(shardOn(2), shardOn(3), shardOn(4))
+			for _, key := range keys(100) {
+				Expect(store.ShardFor(HashTagKey(key, "a"))).To(Equal(store.ShardFor(HashTagKey(key, "b"))))
+			}
+		})
+	})
+
+	Describe("InitSharded", func() {
+		var store *ShardedStore
+
+		BeforeEach(func() {
+			store = NewShardedStore(shardOn(2), shardOn(3))
+			InitSharded(store, nil)
+		})
+
+		AfterEach(func() {
+			Shards = nil
+		})
+
+		It("routes the operations to the shard owning the key", func() {
+			for _, key := range keys(10) {
+				Expect(Set(key, key)).To(Succeed())
+				Expect(Get(key)).To(Equal(key))
+				Expect(store.ClientFor(key).Exists(key).Val()).To(Equal(int64(1)))
+			}
+
+			Expect(Delete(keys(5)...)).To(Succeed())
+			Expect(DeleteMatched("user:*")).To(Equal(int64(5)))
+			for _, client := range store.Clients() {
+				Expect(client.Keys("user:*").Val()).To(BeEmpty())
+			}
+		})
+
+		It("deletes the matched keys left on their previous shards", func() {
+			for _, key := range keys(200) {
+				Expect(Set(key, key)).To(Succeed())
+			}
+			store.AddShard(shardOn(4))
+
+			Expect(DeleteMatched("user:*")).To(Equal(int64(200)))
+			for _, client := range store.Clients() {
+				Expect(client.Keys("user:*").Val()).To(BeEmpty())
+			}
+		})
+
+		It("invalidates the tags across the shards", func() {
+			for _, key := range keys(10) {
+				Expect(Set(key, key, Opt{Tags: []string{"users"}})).To(Succeed())
+			}
+			Expect(InvalidateTags("users")).To(Succeed())
+			Expect(DeleteMatched("user:*")).To(BeZero())
+		})
+	})
+})
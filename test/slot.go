@@ -0,0 +1,28 @@
+package cache_test
+
+import (
+	. "github.com/go-web-kits/cache"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Slot", func() {
+	It("computes the cluster hash slot", func() {
+		Expect(Slot("foo")).To(Equal(12182))
+		Expect(Slot("123456789")).To(Equal(0x31C3 % 16384))
+	})
+
+	It("honors the hash tag", func() {
+		Expect(Slot("{user1000}.following")).To(Equal(Slot("{user1000}.followers")))
+		Expect(Slot("{user1000}.following")).To(Equal(Slot("user1000")))
+		Expect(Slot("foo{}{bar}")).NotTo(Equal(Slot("bar")))
+	})
+
+	Describe("HashTagKey", func() {
+		It("builds a co-located key", func() {
+			Expect(HashTag("user:42")).To(Equal("{user:42}"))
+			Expect(HashTagKey("user:42", "profile")).To(Equal("{user:42}:profile"))
+			Expect(Slot(HashTagKey("user:42", "profile"))).To(Equal(Slot(HashTagKey("user:42", "orders", "1"))))
+		})
+	})
+})
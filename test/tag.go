@@ -0,0 +1,110 @@
+package cache_test
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	. "github.com/go-web-kits/cache"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tag", func() {
+	BeforeEach(func() {
+		Expect(InvalidateTags("user:42", "user:43", "products")).To(Succeed())
+	})
+
+	Describe("InvalidateTags", func() {
+		It("deletes every key under the tags", func() {
+			Expect(Set("tag1", 1, Opt{Tags: []string{"user:42"}})).To(Succeed())
+			Expect(Set("tag2", 2, Opt{Tags: []string{"user:42", "products"}})).To(Succeed())
+			Expect(Set("tag3", 3, Opt{Tags: []string{"user:43"}})).To(Succeed())
+
+			Expect(InvalidateTags("user:42")).To(Succeed())
+			_, err := Get("tag1")
+			Expect(IsKeyNotFound(err)).To(BeTrue())
+			_, err = Get("tag2")
+			Expect(IsKeyNotFound(err)).To(BeTrue())
+			Expect(Get("tag3")).To(Equal(3))
+
+			Expect(TaggedKeys("user:42")).To(BeEmpty())
+			Expect(TaggedKeys("products")).To(BeEmpty())
+			Expect(TaggedKeys("user:43")).To(Equal([]string{"tag3"}))
+		})
+
+		It("records the tags passed to Fetch", func() {
+			Expect(Delete("tag4")).To(Succeed())
+			Expect(Fetch("tag4", Opt{Default: "v", Tags: []string{"products"}})).To(Equal("v"))
+			Expect(TaggedKeys("products")).To(Equal([]string{"tag4"}))
+
+			Expect(InvalidateTags("products")).To(Succeed())
+			_, err := Get("tag4")
+			Expect(IsKeyNotFound(err)).To(BeTrue())
+		})
+
+		When("the key is re-set with other tags", func() {
+			It("drops the stale memberships", func() {
+				Expect(Set("tag5", 1, Opt{Tags: []string{"user:42"}})).To(Succeed())
+				Expect(Set("tag5", 2, Opt{Tags: []string{"user:43"}})).To(Succeed())
+				Expect(TaggedKeys("user:42")).To(BeEmpty())
+
+				Expect(InvalidateTags("user:42")).To(Succeed())
+				Expect(Get("tag5")).To(Equal(2))
+			})
+
+			It("keeps the memberships if the fenced write is rejected", func() {
+				Expect(Set("tag9", 1, Opt{Fence: 2})).To(Succeed())
+				Expect(Set("tag9", 2, Opt{Tags: []string{"user:43"}})).To(Succeed())
+				Expect(errors.Cause(Set("tag9", 3, Opt{Fence: 1}))).To(Equal(ErrStaleFence))
+				Expect(TaggedKeys("user:43")).To(ConsistOf("tag9"))
+
+				Expect(Set("tag9", 4, Opt{Fence: 3})).To(Succeed())
+				Expect(TaggedKeys("user:43")).To(BeEmpty())
+				Expect(Delete("tag9", "{tag9}:__fence")).To(Succeed())
+			})
+		})
+
+		When("the key is re-set without tags", func() {
+			It("drops the memberships", func() {
+				Expect(Set("tag5", 1, Opt{Tags: []string{"user:42"}})).To(Succeed())
+				Expect(Set("tag5", 2)).To(Succeed())
+				Expect(TaggedKeys("user:42")).To(BeEmpty())
+
+				Expect(InvalidateTags("user:42")).To(Succeed())
+				Expect(Get("tag5")).To(Equal(2))
+			})
+
+			It("keeps the memberships if the fenced write is rejected", func() {
+				Expect(Set("tag9", 1, Opt{Fence: 2})).To(Succeed())
+				Expect(Set("tag9", 2, Opt{Tags: []string{"user:43"}})).To(Succeed())
+				Expect(errors.Cause(Set("tag9", 3, Opt{Fence: 1}))).To(Equal(ErrStaleFence))
+				Expect(TaggedKeys("user:43")).To(ConsistOf("tag9"))
+
+				Expect(Set("tag9", 4, Opt{Fence: 3})).To(Succeed())
+				Expect(TaggedKeys("user:43")).To(BeEmpty())
+				Expect(Delete("tag9", "{tag9}:__fence")).To(Succeed())
+			})
+		})
+
+		It("keeps the tag sets as long as their longest-lived keys", func() {
+			Expect(Set("tag6", 1, Opt{Tags: []string{"products"}, ExpiresIn: time.Minute})).To(Succeed())
+			Expect(Cli.PTTL("__tag:products").Val()).To(BeNumerically("~", time.Minute, time.Second))
+			Expect(Cli.PTTL("{tag6}:__tags").Val()).To(BeNumerically("~", time.Minute, time.Second))
+
+			Expect(Set("tag7", 1, Opt{Tags: []string{"products"}, ExpiresIn: time.Second})).To(Succeed())
+			Expect(Cli.PTTL("__tag:products").Val()).To(BeNumerically("~", time.Minute, time.Second))
+
+			Expect(Set("tag8", 1, Opt{Tags: []string{"products"}})).To(Succeed())
+			Expect(Cli.PTTL("__tag:products").Val()).To(Equal(time.Duration(-1)))
+			Expect(Set("tag7", 1, Opt{Tags: []string{"products"}, ExpiresIn: time.Hour})).To(Succeed())
+			Expect(Cli.PTTL("__tag:products").Val()).To(Equal(time.Duration(-1)))
+		})
+
+		When("no tag given", func() {
+			It("does nothing", func() {
+				Expect(InvalidateTags()).To(Succeed())
+			})
+		})
+	})
+})
@@ -0,0 +1,164 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/go-web-kits/cache"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type recordedSpan struct {
+	name   string
+	parent *recordedSpan
+	attrs  map[string]interface{}
+	err    error
+	ended  bool
+}
+
+func (s *recordedSpan) SetAttribute(key string, value interface{}) { s.attrs[key] = value }
+func (s *recordedSpan) RecordError(err error)                      { s.err = err }
+func (s *recordedSpan) End()                                       { s.ended = true }
+
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpanKey struct{}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := ctx.Value(recordedSpanKey{}).(*recordedSpan)
+	span := &recordedSpan{name: name, parent: parent, attrs: map[string]interface{}{}}
+	t.mu.Lock()
+	t.spans = append(t.spans, span)
+	t.mu.Unlock()
+	return context.WithValue(ctx, recordedSpanKey{}, span), span
+}
+
+func (t *recordingTracer) find(name string) []*recordedSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	result := []*recordedSpan{}
+	for _, span := range t.spans {
+		if span.name == name {
+			result = append(result, span)
+		}
+	}
+	return result
+}
+
+var _ = Describe("Tracing", func() {
+	var tracer *recordingTracer
+
+	BeforeEach(func() {
+		Expect(Delete("traced")).To(Succeed())
+		tracer = &recordingTracer{}
+		Tracing = tracer
+	})
+
+	AfterEach(func() {
+		Tracing = nil
+	})
+
+	It("traces Fetch, with the loader and the commands as the children", func() {
+		var loaderCtx context.Context
+		Expect(Fetch("traced", Opt{Default: func(ctx context.Context) interface{} {
+			loaderCtx = ctx
+			return map[string]int{"a": 1}
+		}})).To(Equal(map[string]int{"a": 1}))
+
+		fetch := tracer.find("cache.Fetch")
+		Expect(fetch).To(HaveLen(1))
+		Expect(fetch[0].attrs).To(HaveKeyWithValue("cache.key", "traced"))
+		Expect(fetch[0].attrs).To(HaveKeyWithValue("cache.hit", false))
+		Expect(fetch[0].ended).To(BeTrue())
+
+		loader := tracer.find("cache.Fetch.loader")
+		Expect(loader).To(HaveLen(1))
+		Expect(loader[0].parent).To(Equal(fetch[0]))
+		Expect(loaderCtx.Value(recordedSpanKey{})).To(Equal(loader[0]))
+
+		set := tracer.find("cache.Set")
+		Expect(set).To(HaveLen(1))
+		Expect(set[0].parent).To(Equal(fetch[0]))
+		Expect(set[0].attrs).To(HaveKeyWithValue("cache.codec", "map[string]int"))
+
+		get := tracer.find("redis.get")
+		Expect(get).To(HaveLen(1))
+		Expect(get[0].parent.name).To(Equal("cache.Get"))
+		Expect(get[0].parent.parent).To(Equal(fetch[0]))
+		Expect(tracer.find("redis.set")[0].parent.name).To(Equal("redis.pipeline"))
+		Expect(tracer.find("redis.set")[0].parent.parent).To(Equal(set[0]))
+	})
+
+	It("records the hits and the errors", func() {
+		Expect(Set("traced", "value")).To(Succeed())
+		Expect(Get("traced")).To(Equal("value"))
+		get := tracer.find("cache.Get")
+		Expect(get[0].attrs).To(HaveKeyWithValue("cache.hit", true))
+		Expect(get[0].attrs).To(HaveKeyWithValue("cache.codec", "string"))
+
+		failed := errors.New("failed")
+		Expect(Lock("traced", time.Second, func() error { return failed })).To(Equal(failed))
+		lock := tracer.find("cache.Lock")
+		Expect(lock[0].err).To(Equal(failed))
+		Expect(lock[0].attrs).To(HaveKey("cache.lock.fence"))
+	})
+
+	It("leaves the commands of the operations without a Context as they are", func() {
+		Expect(Set("traced", "value")).To(Succeed())
+		Expect(Get("traced")).To(Equal("value"))
+		Expect(tracer.find("redis.get")[0].parent).To(BeNil())
+		Expect(tracer.find("redis.pipeline")[0].parent).To(BeNil())
+	})
+
+	It("traces the scripts of the tagged & the fenced Sets as the children", func() {
+		ctx, root := tracer.Start(context.Background(), "root")
+		Expect(Set("traced", 1, Opt{Context: ctx, Tags: []string{"products"}})).To(Succeed())
+		Expect(Set("traced", 2, Opt{Context: ctx, Fence: 1})).To(Succeed())
+
+		set := tracer.find("cache.Set")
+		Expect(set).To(HaveLen(2))
+		scripts := append(tracer.find("redis.evalsha"), tracer.find("redis.eval")...)
+		Expect(scripts).NotTo(BeEmpty())
+		for _, script := range scripts {
+			Expect(script.parent).To(BeElementOf(set[0], set[1]))
+		}
+		Expect(set[0].parent).To(Equal(root))
+		Expect(Delete("traced", "{traced}:__fence")).To(Succeed())
+	})
+
+	It("traces LockAutoRenew, with the ctx of the lambda as the child", func() {
+		Expect(Delete("__lock:traced")).To(Succeed())
+		ctx, root := tracer.Start(context.Background(), "root")
+		var lambdaCtx context.Context
+		Expect(LockAutoRenew("traced", time.Second, func(ctx context.Context) error {
+			lambdaCtx = ctx
+			return nil
+		}, LockOpt{Context: ctx})).To(Succeed())
+
+		renew := tracer.find("cache.LockAutoRenew")
+		Expect(renew).To(HaveLen(1))
+		Expect(renew[0].parent).To(Equal(root))
+		Expect(renew[0].ended).To(BeTrue())
+		Expect(lambdaCtx.Value(recordedSpanKey{})).To(Equal(renew[0]))
+	})
+
+	It("traces the pipelines", func() {
+		pipe := Cli.Pipeline()
+		pipe.Set("traced", 1, 0)
+		pipe.Get("traced")
+		_, err := pipe.Exec()
+		Expect(err).NotTo(HaveOccurred())
+
+		pipeline := tracer.find("redis.pipeline")
+		Expect(pipeline).To(HaveLen(1))
+		Expect(pipeline[0].attrs).To(HaveKeyWithValue("db.commands", 2))
+		Expect(tracer.find("redis.get")[0].parent).To(Equal(pipeline[0]))
+		Expect(tracer.find("redis.get")[0].ended).To(BeTrue())
+	})
+})
@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// Tracing starts the spans of the operations (Get, Set, Fetch & its loader, Lock, LockAutoRenew)
+// and of the commands seen by `Hook`, default (nil) is disabled. If `Opt.Context` (`LockOpt.Context`)
+// is given, the span of an operation is the child of the span in it, and the spans of the commands
+// of the operation are the children of its span.
+var Tracing Tracer
+
+// Tracer is the shape of an OpenTelemetry tracer, e.g. adapted by
+//
+//	func (t otelTracer) Start(ctx context.Context, name string) (context.Context, cache.Span) {
+//		ctx, span := t.tracer.Start(ctx, name)
+//		return ctx, otelSpan{span}
+//	}
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is the shape of an OpenTelemetry span.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, interface{}) {}
+func (noopSpan) RecordError(error)                {}
+func (noopSpan) End()                             {}
+
+type spanKey struct{}
+type pipelineSpansKey struct{}
+
+func startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if Tracing == nil {
+		return ctx, noopSpan{}
+	}
+	return Tracing.Start(ctx, name)
+}
+
+// endSpan records the error (except redis.Nil) and ends the span.
+func endSpan(span Span, err error) {
+	if err = filtered(err); err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// codecOf returns the type recorded by `Compress` in the encoded value.
+func codecOf(encoded string) string {
+	decoded, err := decode(encoded)
+	if err != nil {
+		return "raw"
+	}
+	if i := strings.Index(decoded, "##"); i > -1 {
+		return decoded[:i]
+	}
+	return "raw"
+}
+
+// commandContext returns the ctx of an operation (carrying its span) to pass to withContext,
+// or nil (the clients keep their own ctx) unless tracing and `Opt.Context` was given.
+func commandContext(ctx context.Context, opt Opt) context.Context {
+	if Tracing == nil || opt.Context == nil {
+		return nil
+	}
+	return ctx
+}
+
+// withContext returns the client carrying the ctx, so the spans of its commands are the children
+// of the span in the ctx. The client is returned as is if the ctx is nil, as are the other
+// implementations of redis.UniversalClient.
+func withContext(ctx context.Context, cli redis.UniversalClient) redis.UniversalClient {
+	if ctx == nil {
+		return cli
+	}
+	switch cli := cli.(type) {
+	case *redis.Client:
+		return cli.WithContext(ctx)
+	case *redis.ClusterClient:
+		return cli.WithContext(ctx)
+	case *redis.Ring:
+		return cli.WithContext(ctx)
+	}
+	return cli
+}
+
+func startCommandSpan(ctx context.Context, cmd redis.Cmder) (context.Context, Span) {
+	ctx, span := startSpan(ctx, "redis."+cmd.Name())
+	span.SetAttribute("db.system", "redis")
+	span.SetAttribute("db.operation", cmd.Name())
+	return ctx, span
+}
+
+func endCommandSpan(span Span, cmd redis.Cmder) {
+	span.SetAttribute("cache.keys", cmdKeys(cmd))
+	span.SetAttribute("cache.size", resultSize(cmd))
+	endSpan(span, cmd.Err())
+}